@@ -0,0 +1,59 @@
+//
+// compose.go
+//
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of this source code package.
+//
+
+package pipe
+
+import (
+	"io"
+)
+
+// Identity is a Filter that copies the stream without changes.
+func Identity(r io.Reader, w io.Writer) error {
+	_, err := io.Copy(w, r)
+	return err
+}
+
+// Chain returns a Filter that applies filters in order, as if they
+// were pushed one after the other to a Pipe.
+func Chain(filters ...Filter) Filter {
+	return func(r io.Reader, w io.Writer) error {
+		return New(r).Push(filters...).To(w).Exec()
+	}
+}
+
+// If returns a Filter that applies filter if predicate returns true
+// when the stream starts, otherwise the stream is copied without changes.
+func If(predicate func() bool, filter Filter) Filter {
+	return func(r io.Reader, w io.Writer) error {
+		if predicate() {
+			return filter(r, w)
+		}
+		return Identity(r, w)
+	}
+}
+
+// Map returns a Filter that applies fn to each chunk of the stream
+// and writes the result. Chunks have no fixed size, fn should not
+// depend on the boundaries of the chunks.
+func Map(fn func([]byte) []byte) Filter {
+	return func(r io.Reader, w io.Writer) error {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				if _, werr := w.Write(fn(buf[:n])); werr != nil {
+					return werr
+				}
+			}
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,182 @@
+//
+// digest.go
+//
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of this source code package.
+//
+
+package pipe
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"sync"
+)
+
+// Digest algorithms supported by NewDigest.
+const (
+	MD5    = "md5"
+	SHA1   = "sha1"
+	SHA256 = "sha256"
+	SHA512 = "sha512"
+	CRC32C = "crc32c"
+)
+
+// DigestMismatchError is returned by a verifying Digest when the digest
+// of the stream is not the expected one.
+type DigestMismatchError struct {
+	Algorithm string
+	Expected  string
+	Actual    string
+}
+
+func (e *DigestMismatchError) Error() string {
+	return fmt.Sprintf("%s digest mismatch: expected %s, got %s", e.Algorithm, e.Expected, e.Actual)
+}
+
+// Digest computes the hash of the stream that passes through it.
+// Use Pipe.PushDigest to add it to a Pipe.
+type Digest struct {
+	Algorithm string
+
+	expected []byte
+	mu       sync.Mutex
+	hash     hash.Hash
+	sum      []byte
+}
+
+// newHash returns a new hash.Hash for algorithm.
+func newHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case MD5:
+		return md5.New(), nil
+	case SHA1:
+		return sha1.New(), nil
+	case SHA256:
+		return sha256.New(), nil
+	case SHA512:
+		return sha512.New(), nil
+	case CRC32C:
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli)), nil
+	}
+	return nil, fmt.Errorf("digest algorithm '%s' is not supported", algorithm)
+}
+
+// NewDigest creates a Digest for algorithm.
+func NewDigest(algorithm string) (*Digest, error) {
+	h, err := newHash(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return &Digest{Algorithm: algorithm, hash: h}, nil
+}
+
+// NewVerifier creates a Digest that fails at the end of the stream
+// if its digest is not expected (hex encoded). The last bytes of the
+// stream are held back until the digest is verified, so the next stages
+// never receive the complete stream if it does not match.
+func NewVerifier(algorithm, expected string) (*Digest, error) {
+	d, err := NewDigest(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if d.expected, err = hex.DecodeString(expected); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// verifyHoldSize is the maximum number of bytes a verifying Digest
+// holds back at the end of the stream.
+const verifyHoldSize = 32 * 1024
+
+// Filter copies the stream and computes its digest.
+func (d *Digest) Filter(r io.Reader, w io.Writer) error {
+	d.hash.Reset()
+	if d.expected != nil {
+		return d.verify(r, w)
+	}
+	if _, err := io.Copy(io.MultiWriter(w, d.hash), r); err != nil {
+		return err
+	}
+	d.setSum()
+	return nil
+}
+
+// verify copies the stream like Filter, the last bytes are only written
+// once the digest matches.
+func (d *Digest) verify(r io.Reader, w io.Writer) error {
+	buf := make([]byte, verifyHoldSize)
+	held := make([]byte, 0, verifyHoldSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			d.hash.Write(buf[:n])
+			if len(held) > 0 {
+				if _, werr := w.Write(held); werr != nil {
+					return werr
+				}
+			}
+			held = append(held[:0], buf[:n]...)
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if sum := d.setSum(); !bytes.Equal(sum, d.expected) {
+		return &DigestMismatchError{
+			Algorithm: d.Algorithm,
+			Expected:  hex.EncodeToString(d.expected),
+			Actual:    hex.EncodeToString(sum),
+		}
+	} else if len(held) > 0 {
+		_, err := w.Write(held)
+		return err
+	}
+	return nil
+}
+
+// setSum sets and returns the digest of the complete stream.
+func (d *Digest) setSum() []byte {
+	sum := d.hash.Sum(nil)
+	d.mu.Lock()
+	d.sum = sum
+	d.mu.Unlock()
+	return sum
+}
+
+// Sum returns the digest, or nil if the stream is not complete.
+func (d *Digest) Sum() []byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.sum
+}
+
+// Hex returns the digest encoded in hexadecimal, or an empty string
+// if the stream is not complete.
+func (d *Digest) Hex() string {
+	return hex.EncodeToString(d.Sum())
+}
+
+// PushDigest appends d to the Pipe, the digest is available
+// from d or with Digests when Exec returns.
+func (p *Pipe) PushDigest(d *Digest) *Pipe {
+	p.digests = append(p.digests, d)
+	return p.PushNamed("digest "+d.Algorithm, d.Filter)
+}
+
+// Digests returns the Digests pushed to the Pipe in order.
+func (p *Pipe) Digests() []*Digest {
+	return p.digests
+}
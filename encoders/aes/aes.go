@@ -1,16 +1,45 @@
 package aes
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"errors"
 	"io"
+
+	"github.com/hyperboloide/pipe/encoders"
+	"github.com/hyperboloide/pipe/encoders/keyring"
+)
+
+const (
+	// Version of the stream format.
+	Version = 1
+	// VersionKeyID is the version of the stream format with a key ID,
+	// see KeyIDMagic.
+	VersionKeyID = 2
 )
 
+// KeyIDMagic starts the streams encrypted with a key of a Keyring, it is
+// followed by the key ID (see keyring.WriteID), the IV and an HMAC-SHA256
+// of the header. The streams of the legacy format start with a random IV
+// instead: a header is only read if its HMAC is valid.
+var KeyIDMagic = []byte("AESK")
+
+// ErrInvalidHeader is returned when a stream starts with KeyIDMagic but
+// its header cannot be verified and there is no legacy Key to decode it.
+var ErrInvalidHeader = errors.New("invalid aes key ID header")
+
 // AES encryption encrypts with a key 256 bits key using an
 // aes 256 cfb scheme and an IV that is appended to the output stream.
+//
+// With a Keyring the stream is encrypted with the active key and starts
+// with KeyIDMagic and the ID of the key, the header is authenticated so
+// that streams encrypted with Key can still be decoded if Key is also set.
 type AES struct {
 	// The encryption key must be 256 bits long.
 	Key []byte
@@ -18,11 +47,19 @@ type AES struct {
 	// Alternativly to the key, a b64 encoded string can be set as the key
 	// and will be decoded on start if Key is nil.
 	KeyB64 string `json:"key"`
+
+	// Keys of 256 bits, the active key is used to encrypt instead of Key.
+	keyring.Keyring
 }
 
 // Start the encoder.
 func (a *AES) Start() error {
-	if a.Key == nil && a.KeyB64 == "" {
+	if err := a.Keyring.Start(32); err != nil {
+		return err
+	} else if a.Key == nil && a.KeyB64 == "" {
+		if a.Keyring.Enabled() {
+			return nil
+		}
 		return errors.New("key undefined for AES")
 	}
 	if a.Key == nil {
@@ -38,17 +75,52 @@ func (a *AES) Start() error {
 	return nil
 }
 
+// Describe the AES encoder in an envelope header.
+func (a *AES) Describe() encoders.Layer {
+	if a.Keyring.Enabled() {
+		return encoders.Layer{
+			Type:    "aes",
+			Version: VersionKeyID,
+			Params:  map[string]interface{}{"key_id": a.Keyring.Active},
+		}
+	}
+	return encoders.Layer{Type: "aes", Version: Version}
+}
+
+// headerMAC returns the HMAC-SHA256 of a key ID header, its key is
+// derived from the encryption key.
+func headerMAC(key, header []byte) []byte {
+	derive := hmac.New(sha256.New, key)
+	derive.Write([]byte("aes key id header"))
+	mac := hmac.New(sha256.New, derive.Sum(nil))
+	mac.Write(header)
+	return mac.Sum(nil)
+}
+
 // Encode encrypts a stream with the key and generates an IV
 // that will be appended to the stream.
 func (a *AES) Encode(r io.Reader, w io.Writer) error {
 	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return err
+	}
+
+	key, header := a.Key, iv
+	if a.Keyring.Enabled() {
+		var id string
+		id, key = a.Keyring.ActiveKey()
+		buf := bytes.NewBuffer(append([]byte{}, KeyIDMagic...))
+		if err := keyring.WriteID(buf, id); err != nil {
+			return err
+		}
+		buf.Write(iv)
+		header = append(buf.Bytes(), headerMAC(key, buf.Bytes())...)
+	}
 
-	block, err := aes.NewCipher(a.Key)
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return err
-	} else if _, err = rand.Read(iv[:]); err != nil {
-		return err
-	} else if _, err = w.Write(iv); err != nil {
+	} else if _, err = w.Write(header); err != nil {
 		return err
 	}
 	stream := cipher.NewCFBEncrypter(block, iv)
@@ -57,18 +129,68 @@ func (a *AES) Encode(r io.Reader, w io.Writer) error {
 	return err
 }
 
-// Decode an encrypted stream that start with an IV.
-func (a *AES) Decode(r io.Reader, w io.Writer) error {
+// decodeHeader reads the header at the start of r and returns the key and
+// the IV to decrypt the stream. If a key ID header cannot be verified the
+// stream is decoded with the legacy Key when it is set.
+func (a *AES) decodeHeader(r *bufio.Reader) ([]byte, []byte, error) {
+	if a.Keyring.Enabled() {
+		key, header, err := a.verifyHeader(r)
+		if err == nil {
+			iv := append([]byte{}, header[len(header)-sha256.Size-aes.BlockSize:len(header)-sha256.Size]...)
+			_, err = r.Discard(len(header))
+			return key, iv, err
+		} else if a.Key == nil {
+			return nil, nil, err
+		}
+	}
 	iv := make([]byte, aes.BlockSize)
+	_, err := io.ReadFull(r, iv)
+	return a.Key, iv, err
+}
 
-	block, err := aes.NewCipher(a.Key)
+// verifyHeader peeks a key ID header at the start of r and returns its
+// key and the header if it is valid.
+func (a *AES) verifyHeader(r *bufio.Reader) ([]byte, []byte, error) {
+	start, err := r.Peek(len(KeyIDMagic) + 1)
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	} else if err == io.EOF || !bytes.Equal(start[:len(KeyIDMagic)], KeyIDMagic) {
+		return nil, nil, errors.New("stream does not start with a key ID")
+	}
+	signed := len(start) + int(start[len(KeyIDMagic)]) + aes.BlockSize
+	header, err := r.Peek(signed + sha256.Size)
+	if err == io.EOF {
+		return nil, nil, ErrInvalidHeader
+	} else if err != nil {
+		return nil, nil, err
+	}
+	id, err := keyring.ReadID(bytes.NewReader(header[len(KeyIDMagic):]))
+	if err != nil {
+		return nil, nil, ErrInvalidHeader
+	}
+	key, err := a.Keyring.Key(id)
+	if err != nil {
+		return nil, nil, err
+	} else if !hmac.Equal(header[signed:], headerMAC(key, header[:signed])) {
+		return nil, nil, ErrInvalidHeader
+	}
+	return key, header, nil
+}
+
+// Decode an encrypted stream that start with an IV.
+func (a *AES) Decode(r io.Reader, w io.Writer) error {
+	br := bufio.NewReader(r)
+	key, iv, err := a.decodeHeader(br)
 	if err != nil {
 		return err
-	} else if _, err = r.Read(iv[:]); err != nil {
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
 		return err
 	}
 	stream := cipher.NewCFBDecrypter(block, iv)
-	reader := &cipher.StreamReader{S: stream, R: r}
+	reader := &cipher.StreamReader{S: stream, R: br}
 	_, err = io.Copy(w, reader)
 	return err
 }
@@ -1,10 +1,17 @@
 package aes_test
 
 import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/base64"
 	"errors"
+	"strings"
 	"testing"
 
+	goaes "crypto/aes"
+
 	"github.com/hyperboloide/pipe/encoders/aes"
+	"github.com/hyperboloide/pipe/encoders/keyring"
 	"github.com/hyperboloide/pipe/tests"
 )
 
@@ -25,3 +32,62 @@ func TestAES(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestAESKeyRotation(t *testing.T) {
+	k1, _ := aes.GenKey()
+	k2, _ := aes.GenKey()
+
+	legacy := &aes.AES{KeyB64: k1}
+	old := &aes.AES{Keyring: keyring.Keyring{KeysB64: map[string]string{"k1": k1}}}
+	rotated := &aes.AES{
+		KeyB64:  k1,
+		Keyring: keyring.Keyring{KeysB64: map[string]string{"k1": k1, "k2": k2}, Active: "k2"},
+	}
+	for _, enc := range []*aes.AES{legacy, old, rotated} {
+		if err := enc.Start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tests.TestEncoderDecoder(rotated, "../../tests/test.jpg"); err != nil {
+		t.Error(err)
+	}
+
+	// streams of the legacy key and the old keyring are decoded
+	// with the rotated keyring.
+	for _, enc := range []*aes.AES{legacy, old} {
+		var encoded, decoded bytes.Buffer
+		if err := enc.Encode(strings.NewReader("some data"), &encoded); err != nil {
+			t.Fatal(err)
+		} else if err := rotated.Decode(&encoded, &decoded); err != nil {
+			t.Error(err)
+		} else if decoded.String() != "some data" {
+			t.Error(errors.New("decoded data do not match"))
+		}
+	}
+	// a legacy stream with an IV that starts with KeyIDMagic is not read
+	// as a key ID header.
+	key, _ := base64.StdEncoding.DecodeString(k1)
+	block, _ := goaes.NewCipher(key)
+	iv := append(append([]byte{}, aes.KeyIDMagic...), 2, 'k', '1', 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	var encoded, decoded bytes.Buffer
+	encoded.Write(iv)
+	w := &cipher.StreamWriter{S: cipher.NewCFBEncrypter(block, iv), W: &encoded}
+	w.Write([]byte("some data"))
+	if err := rotated.Decode(&encoded, &decoded); err != nil {
+		t.Error(err)
+	} else if decoded.String() != "some data" {
+		t.Error(errors.New("legacy stream should be decoded with the legacy key"))
+	}
+
+	// without legacy key a header that is not valid is refused.
+	encoded.Reset()
+	if err := old.Encode(strings.NewReader("some data"), &encoded); err != nil {
+		t.Fatal(err)
+	}
+	tampered := encoded.Bytes()
+	tampered[len(aes.KeyIDMagic)+4]++
+	if err := old.Decode(bytes.NewReader(tampered), &bytes.Buffer{}); err != aes.ErrInvalidHeader {
+		t.Errorf("tampered header should be refused, got %v", err)
+	}
+}
@@ -0,0 +1,268 @@
+package aesgcm
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+
+	"github.com/hyperboloide/pipe/encoders"
+	"github.com/hyperboloide/pipe/encoders/keyring"
+)
+
+const (
+	// Version of the stream format.
+	Version = 1
+	// VersionKeyID is the version of the stream format with a key ID,
+	// used with a Keyring.
+	VersionKeyID = 2
+	// DefaultSegmentSize is the size of the plaintext of a segment.
+	DefaultSegmentSize = 64 * 1024
+	// MaxSegmentSize is the maximum size of the plaintext of a segment.
+	MaxSegmentSize = 16 * 1024 * 1024
+
+	prefixSize = 7
+	headerSize = 1 + 4 + prefixSize
+)
+
+var (
+	// ErrInvalidHeader is returned if the stream does not start with a valid header.
+	ErrInvalidHeader = errors.New("aesgcm: invalid header")
+	// ErrInvalidSegment is returned if a segment was modified, reordered or removed.
+	ErrInvalidSegment = errors.New("aesgcm: invalid segment, the stream was modified")
+	// ErrTruncated is returned if the stream ends before the final segment.
+	ErrTruncated = errors.New("aesgcm: stream truncated before the final segment")
+	// ErrTooManySegments is returned if the stream is too long for the segment size.
+	ErrTooManySegments = errors.New("aesgcm: too many segments")
+)
+
+// AESGCM encrypts with a 256 bits key using aes 256 gcm. The stream is
+// split in segments that are authenticated separately so that the stream
+// can be decoded without buffering it entirely.
+// Each segment uses a nonce made of a random prefix, the index of the
+// segment and a flag set on the final segment (the STREAM construction),
+// so that modified, reordered and missing segments are detected.
+//
+// The output starts with a header: a version byte, the segment size
+// (uint32 big endian) and the nonce prefix. Then each segment is
+// encrypted as SegmentSize bytes of plaintext followed by the tag,
+// the final segment can be shorter.
+//
+// With a Keyring the stream is encrypted with the active key and the
+// header (version VersionKeyID) ends with the ID of the key, see
+// keyring.WriteID. Streams encrypted with Key can still be decoded if
+// Key is also set.
+type AESGCM struct {
+	// The encryption key must be 256 bits long.
+	Key []byte
+
+	// Alternativly to the key, a b64 encoded string can be set as the key
+	// and will be decoded on start if Key is nil. Keys of the aes encoder
+	// can be used.
+	KeyB64 string `json:"key"`
+
+	// Size of the plaintext of a segment, defaults to DefaultSegmentSize.
+	// Only used to encode, the decoder reads it from the header.
+	SegmentSize int `json:"segment_size"`
+
+	// Keys of 256 bits, the active key is used to encrypt instead of Key.
+	keyring.Keyring
+}
+
+// Start the encoder.
+func (a *AESGCM) Start() error {
+	if err := a.Keyring.Start(32); err != nil {
+		return err
+	} else if a.Key == nil && a.KeyB64 == "" && !a.Keyring.Enabled() {
+		return errors.New("key undefined for AESGCM")
+	}
+	if a.Key == nil && a.KeyB64 != "" {
+		res, err := base64.StdEncoding.DecodeString(a.KeyB64)
+		if err != nil {
+			return err
+		}
+		a.Key = res
+	}
+	if a.Key != nil && len(a.Key) != 32 {
+		return errors.New("key size must be 32 bytes")
+	}
+	if a.SegmentSize == 0 {
+		a.SegmentSize = DefaultSegmentSize
+	} else if a.SegmentSize < 0 || a.SegmentSize > MaxSegmentSize {
+		return errors.New("segment size must be between 1 and 16MB")
+	}
+	return nil
+}
+
+func aead(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// nonce returns the nonce of the segment at index.
+func nonce(prefix []byte, index uint64, last bool) []byte {
+	n := make([]byte, prefixSize+5)
+	copy(n, prefix)
+	binary.BigEndian.PutUint32(n[prefixSize:], uint32(index))
+	if last {
+		n[prefixSize+4] = 1
+	}
+	return n
+}
+
+// isLast returns true if r has no more data.
+func isLast(r *bufio.Reader) (bool, error) {
+	if _, err := r.Peek(1); err == io.EOF {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// Describe the AESGCM encoder in an envelope header.
+// The segment size is part of the stream header.
+func (a *AESGCM) Describe() encoders.Layer {
+	if a.Keyring.Enabled() {
+		return encoders.Layer{
+			Type:    "aesgcm",
+			Version: VersionKeyID,
+			Params:  map[string]interface{}{"key_id": a.Keyring.Active},
+		}
+	}
+	return encoders.Layer{Type: "aesgcm", Version: Version}
+}
+
+// Encode encrypts a stream with the key.
+func (a *AESGCM) Encode(r io.Reader, w io.Writer) error {
+	key := a.Key
+	header := make([]byte, headerSize)
+	header[0] = Version
+	binary.BigEndian.PutUint32(header[1:], uint32(a.SegmentSize))
+	if _, err := rand.Read(header[5:]); err != nil {
+		return err
+	}
+	prefix := header[5:]
+	if a.Keyring.Enabled() {
+		var id string
+		id, key = a.Keyring.ActiveKey()
+		header[0] = VersionKeyID
+		buf := bytes.NewBuffer(header)
+		if err := keyring.WriteID(buf, id); err != nil {
+			return err
+		}
+		header = buf.Bytes()
+	}
+
+	gcm, err := aead(key)
+	if err != nil {
+		return err
+	} else if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(r)
+	plain := make([]byte, a.SegmentSize)
+	sealed := make([]byte, 0, a.SegmentSize+gcm.Overhead())
+	for index := uint64(0); ; index++ {
+		if index > math.MaxUint32 {
+			return ErrTooManySegments
+		}
+		n, err := io.ReadFull(br, plain)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return err
+		} else if !last {
+			if last, err = isLast(br); err != nil {
+				return err
+			}
+		}
+		sealed = gcm.Seal(sealed[:0], nonce(prefix, index, last), plain[:n], header)
+		if _, err := w.Write(sealed); err != nil {
+			return err
+		} else if last {
+			return nil
+		}
+	}
+}
+
+// Decode an encrypted stream, an error is returned as soon as a segment
+// is not valid. Note that the segments before are already written to w.
+func (a *AESGCM) Decode(r io.Reader, w io.Writer) error {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return ErrInvalidHeader
+	}
+	size := int(binary.BigEndian.Uint32(header[1:]))
+	if size < 1 || size > MaxSegmentSize {
+		return ErrInvalidHeader
+	}
+	prefix := header[5:]
+
+	key := a.Key
+	switch header[0] {
+	case Version:
+		if key == nil {
+			return errors.New("aesgcm: key undefined to decode a stream without key ID")
+		}
+	case VersionKeyID:
+		id, err := keyring.ReadID(r)
+		if err != nil {
+			return ErrInvalidHeader
+		} else if key, err = a.Keyring.Key(id); err != nil {
+			return err
+		}
+		buf := bytes.NewBuffer(header)
+		keyring.WriteID(buf, id)
+		header = buf.Bytes()
+	default:
+		return ErrInvalidHeader
+	}
+
+	gcm, err := aead(key)
+	if err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(r)
+	sealed := make([]byte, size+gcm.Overhead())
+	plain := make([]byte, 0, size)
+	for index := uint64(0); ; index++ {
+		if index > math.MaxUint32 {
+			return ErrTooManySegments
+		}
+		n, err := io.ReadFull(br, sealed)
+		if err == io.EOF {
+			return ErrTruncated
+		}
+		last := err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return err
+		} else if !last {
+			if last, err = isLast(br); err != nil {
+				return err
+			}
+		}
+		plain, err = gcm.Open(plain[:0], nonce(prefix, index, last), sealed[:n], header)
+		if err != nil {
+			// a valid segment that is not the final one was read last.
+			if _, nerr := gcm.Open(nil, nonce(prefix, index, false), sealed[:n], header); last && nerr == nil {
+				return ErrTruncated
+			}
+			return ErrInvalidSegment
+		} else if _, err := w.Write(plain); err != nil {
+			return err
+		} else if last {
+			return nil
+		}
+	}
+}
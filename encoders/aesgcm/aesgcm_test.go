@@ -0,0 +1,130 @@
+package aesgcm_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/hyperboloide/pipe/encoders/aes"
+	"github.com/hyperboloide/pipe/encoders/aesgcm"
+	"github.com/hyperboloide/pipe/encoders/keyring"
+	"github.com/hyperboloide/pipe/tests"
+)
+
+func TestAESGCM(t *testing.T) {
+
+	enc := &aesgcm.AESGCM{}
+	if err := enc.Start(); err == nil {
+		t.Error(errors.New("should validate that the key is present"))
+	}
+
+	k, err := aes.GenKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc = &aesgcm.AESGCM{KeyB64: k, SegmentSize: 1024}
+
+	if err := tests.TestEncoderDecoder(enc, "../../tests/test.jpg"); err != nil {
+		t.Error(err)
+	}
+
+	empty := &bytes.Buffer{}
+	if err := enc.Encode(bytes.NewReader(nil), empty); err != nil {
+		t.Fatal(err)
+	} else if err := enc.Decode(empty, ioutil.Discard); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAESGCMTampering(t *testing.T) {
+	k, err := aes.GenKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := &aesgcm.AESGCM{KeyB64: k, SegmentSize: 1024}
+	if err := enc.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 4 full segments, the last one is the final segment.
+	data := make([]byte, 4096)
+	encoded := &bytes.Buffer{}
+	if err := enc.Encode(bytes.NewReader(data), encoded); err != nil {
+		t.Fatal(err)
+	}
+	const header, segment = 12, 1024 + 16
+	src := encoded.Bytes()
+	if len(src) != header+4*segment {
+		t.Fatalf("invalid encoded size %d", len(src))
+	}
+
+	flipped := append([]byte{}, src...)
+	flipped[header+segment+10] ^= 1
+
+	reordered := append([]byte{}, src[:header]...)
+	reordered = append(reordered, src[header+segment:header+2*segment]...)
+	reordered = append(reordered, src[header:header+segment]...)
+	reordered = append(reordered, src[header+2*segment:]...)
+
+	cases := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"bit flip", flipped, aesgcm.ErrInvalidSegment},
+		{"reordered", reordered, aesgcm.ErrInvalidSegment},
+		{"truncated segment", src[:len(src)-100], aesgcm.ErrInvalidSegment},
+		{"missing final segment", src[:header+3*segment], aesgcm.ErrTruncated},
+		{"header only", src[:header], aesgcm.ErrTruncated},
+		{"no header", src[:5], aesgcm.ErrInvalidHeader},
+	}
+	for _, c := range cases {
+		if err := enc.Decode(bytes.NewReader(c.data), ioutil.Discard); err != c.err {
+			t.Errorf("%s: expected error %v, got %v", c.name, c.err, err)
+		}
+	}
+}
+
+func TestAESGCMKeyRotation(t *testing.T) {
+	k1, _ := aes.GenKey()
+	k2, _ := aes.GenKey()
+
+	legacy := &aesgcm.AESGCM{KeyB64: k1}
+	old := &aesgcm.AESGCM{Keyring: keyring.Keyring{KeysB64: map[string]string{"k1": k1}}}
+	rotated := &aesgcm.AESGCM{
+		KeyB64:  k1,
+		Keyring: keyring.Keyring{KeysB64: map[string]string{"k1": k1, "k2": k2}, Active: "k2"},
+	}
+	for _, enc := range []*aesgcm.AESGCM{legacy, old, rotated} {
+		if err := enc.Start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tests.TestEncoderDecoder(rotated, "../../tests/test.jpg"); err != nil {
+		t.Error(err)
+	}
+
+	for _, enc := range []*aesgcm.AESGCM{legacy, old} {
+		var encoded, decoded bytes.Buffer
+		if err := enc.Encode(bytes.NewReader([]byte("some data")), &encoded); err != nil {
+			t.Fatal(err)
+		} else if err := rotated.Decode(&encoded, &decoded); err != nil {
+			t.Error(err)
+		} else if decoded.String() != "some data" {
+			t.Error(errors.New("decoded data do not match"))
+		}
+	}
+
+	// the key ID is authenticated.
+	var encoded bytes.Buffer
+	if err := rotated.Encode(bytes.NewReader([]byte("some data")), &encoded); err != nil {
+		t.Fatal(err)
+	}
+	src := encoded.Bytes()
+	src[len(src)-1-16-len("some data")-1] = '1'
+	if err := rotated.Decode(bytes.NewReader(src), ioutil.Discard); err == nil {
+		t.Error("decoding with a modified key ID should fail")
+	}
+}
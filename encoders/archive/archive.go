@@ -0,0 +1,117 @@
+// Package archive provides encoders that wrap a stream as an entry of
+// a tar or zip archive, and decoders that extract an entry.
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultEntryName is the name of the entry if Entry.Name is empty.
+	DefaultEntryName = "data"
+	// DefaultMaxSpoolSize is the maximum size of a temporary file if
+	// Entry.MaxSpoolSize is not set.
+	DefaultMaxSpoolSize = 1 << 30
+)
+
+var (
+	// ErrEntryNotFound is returned when the entry to extract is not in the archive.
+	ErrEntryNotFound = errors.New("entry not found in the archive")
+	// ErrSpoolTooLarge is returned when a stream is larger than the
+	// maximum size of its temporary file.
+	ErrSpoolTooLarge = errors.New("stream is too large to be copied in a temporary file")
+)
+
+// Entry configures the entry of an archive.
+type Entry struct {
+	// Name of the entry to create, defaults to DefaultEntryName.
+	// When decoding the entry to extract, if empty the first file
+	// of the archive is extracted.
+	Name string `json:"name"`
+
+	// Permissions of the entry in octal, defaults to "0644".
+	Mode string `json:"mode"`
+
+	// Modification time of the entry in RFC 3339 format, defaults to
+	// the time of the encoding.
+	MTime string `json:"mtime"`
+
+	// Directory of the temporary files, defaults to os.TempDir().
+	TempDir string `json:"temp_dir"`
+
+	// Maximum size in bytes of a temporary file, defaults to
+	// DefaultMaxSpoolSize. Larger streams fail with ErrSpoolTooLarge.
+	MaxSpoolSize int64 `json:"max_spool_size"`
+
+	mode  os.FileMode
+	mtime time.Time
+}
+
+// Start validates the Entry.
+func (e *Entry) Start() error {
+	if e.Mode == "" {
+		e.mode = 0644
+	} else if m, err := strconv.ParseUint(e.Mode, 8, 32); err != nil {
+		return fmt.Errorf("invalid entry mode '%s'", e.Mode)
+	} else {
+		e.mode = os.FileMode(m).Perm()
+	}
+	if e.MTime != "" {
+		t, err := time.Parse(time.RFC3339, e.MTime)
+		if err != nil {
+			return fmt.Errorf("invalid entry mtime '%s'", e.MTime)
+		}
+		e.mtime = t
+	}
+	if e.MaxSpoolSize == 0 {
+		e.MaxSpoolSize = DefaultMaxSpoolSize
+	} else if e.MaxSpoolSize < 0 {
+		return errors.New("max_spool_size should be a positive number of bytes")
+	}
+	return nil
+}
+
+func (e *Entry) name() string {
+	if e.Name == "" {
+		return DefaultEntryName
+	}
+	return e.Name
+}
+
+func (e *Entry) modTime() time.Time {
+	if e.mtime.IsZero() {
+		return time.Now()
+	}
+	return e.mtime
+}
+
+// spool copies r in a temporary file of up to MaxSpoolSize bytes, the
+// file must be closed and removed with release.
+func (e *Entry) spool(r io.Reader) (*os.File, int64, error) {
+	f, err := ioutil.TempFile(e.TempDir, "pipe-archive-")
+	if err != nil {
+		return nil, 0, err
+	}
+	size, err := io.Copy(f, io.LimitReader(r, e.MaxSpoolSize+1))
+	if err == nil && size > e.MaxSpoolSize {
+		err = fmt.Errorf("%w: more than %d bytes", ErrSpoolTooLarge, e.MaxSpoolSize)
+	} else if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		release(f)
+		return nil, 0, err
+	}
+	return f, size, nil
+}
+
+func release(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+}
@@ -0,0 +1,119 @@
+package archive_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/hyperboloide/pipe/encoders"
+	"github.com/hyperboloide/pipe/encoders/archive"
+	"github.com/hyperboloide/pipe/tests"
+)
+
+func TestArchive(t *testing.T) {
+
+	encs := []encoders.EncoderDecoder{
+		&archive.Tar{},
+		&archive.Tar{Gzip: true, Level: 9, Entry: archive.Entry{Name: "test.jpg", Mode: "0600"}},
+		&archive.Zip{},
+		&archive.Zip{Method: "store", Entry: archive.Entry{MTime: "2017-10-29T10:00:00Z"}},
+	}
+	for i, enc := range encs {
+		if err := tests.TestEncoderDecoder(enc, "../../tests/test.jpg"); err != nil {
+			t.Errorf("encoder %d: %s", i, err)
+		}
+	}
+
+	if err := (&archive.Tar{Entry: archive.Entry{Mode: "999"}}).Start(); err == nil {
+		t.Error("mode should not be valid")
+	} else if err := (&archive.Zip{Entry: archive.Entry{MTime: "yesterday"}}).Start(); err == nil {
+		t.Error("mtime should not be valid")
+	} else if err := (&archive.Zip{Entry: archive.Entry{MaxSpoolSize: -1}}).Start(); err == nil {
+		t.Error("max spool size should not be valid")
+	}
+}
+
+func TestArchiveSpoolSize(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	if w, err := zw.Create("a.txt"); err != nil {
+		t.Fatal(err)
+	} else if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	zw.Close()
+
+	// the tar encoder and the zip decoder copy their input in a temporary file.
+	tr := &archive.Tar{Entry: archive.Entry{MaxSpoolSize: int64(len(data))}}
+	zr := &archive.Zip{Entry: archive.Entry{MaxSpoolSize: int64(zipped.Len())}}
+	if err := tr.Start(); err != nil {
+		t.Fatal(err)
+	} else if err := zr.Start(); err != nil {
+		t.Fatal(err)
+	} else if err := tr.Encode(bytes.NewReader(data), ioutil.Discard); err != nil {
+		t.Error(err)
+	} else if err := zr.Decode(bytes.NewReader(zipped.Bytes()), ioutil.Discard); err != nil {
+		t.Error(err)
+	}
+
+	tr.MaxSpoolSize--
+	zr.MaxSpoolSize--
+	if err := tr.Encode(bytes.NewReader(data), ioutil.Discard); !errors.Is(err, archive.ErrSpoolTooLarge) {
+		t.Errorf("tar should fail with ErrSpoolTooLarge, got %v", err)
+	} else if err := zr.Decode(bytes.NewReader(zipped.Bytes()), ioutil.Discard); !errors.Is(err, archive.ErrSpoolTooLarge) {
+		t.Errorf("zip should fail with ErrSpoolTooLarge, got %v", err)
+	}
+}
+
+func TestArchiveExtract(t *testing.T) {
+	entries := []struct{ name, content string }{
+		{"a.txt", "first"},
+		{"b.txt", "second"},
+	}
+
+	var tarBuf, zipBuf bytes.Buffer
+	tw, zw := tar.NewWriter(&tarBuf), zip.NewWriter(&zipBuf)
+	for _, e := range entries {
+		if err := tw.WriteHeader(&tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.content))}); err != nil {
+			t.Fatal(err)
+		} else if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		} else if w, err := zw.Create(e.name); err != nil {
+			t.Fatal(err)
+		} else if _, err := w.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+	zw.Close()
+
+	cases := []struct {
+		name, expected string
+		err            error
+	}{
+		{"", "first", nil},
+		{"b.txt", "second", nil},
+		{"c.txt", "", archive.ErrEntryNotFound},
+	}
+	for _, c := range cases {
+		decs := map[string]encoders.Decoder{
+			"tar": &archive.Tar{Entry: archive.Entry{Name: c.name}},
+			"zip": &archive.Zip{Entry: archive.Entry{Name: c.name}},
+		}
+		archives := map[string][]byte{"tar": tarBuf.Bytes(), "zip": zipBuf.Bytes()}
+		for typ, dec := range decs {
+			var res bytes.Buffer
+			if err := dec.Start(); err != nil {
+				t.Fatal(err)
+			} else if err := dec.Decode(bytes.NewReader(archives[typ]), &res); err != c.err {
+				t.Errorf("%s entry '%s': expected error %v, got %v", typ, c.name, c.err, err)
+			} else if res.String() != c.expected {
+				t.Errorf("%s entry '%s': expected '%s', got '%s'", typ, c.name, c.expected, res.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+
+	"github.com/hyperboloide/pipe/encoders"
+)
+
+// Tar wraps a stream as the single entry of a tar archive, optionally
+// compressed with gzip (tar.gz). The size of an entry is written before
+// its content so Encode copies the whole stream in a temporary file of
+// TempDir first, it fails with ErrSpoolTooLarge after MaxSpoolSize bytes.
+// Decode extracts an entry of a tar archive without temporary file.
+type Tar struct {
+	Entry
+
+	// Compress the archive with gzip.
+	Gzip bool `json:"gzip"`
+
+	// Level of the gzip compression, see encoders.CompressionLevel.
+	Level int `json:"level"`
+}
+
+// Start the Tar encoder.
+func (t *Tar) Start() error {
+	level, err := encoders.CompressionLevel(t.Level)
+	if err != nil {
+		return err
+	}
+	t.Level = level
+	return t.Entry.Start()
+}
+
+// Describe the Tar encoder in an envelope header.
+func (t *Tar) Describe() encoders.Layer {
+	return encoders.Layer{
+		Type:    "tar",
+		Version: 1,
+		Params:  map[string]interface{}{"name": t.name(), "gzip": t.Gzip},
+	}
+}
+
+// Encode the stream as an entry of a tar archive.
+func (t *Tar) Encode(r io.Reader, w io.Writer) error {
+	f, size, err := t.spool(r)
+	if err != nil {
+		return err
+	}
+	defer release(f)
+
+	out := w
+	var gzw *gzip.Writer
+	if t.Gzip {
+		if gzw, err = gzip.NewWriterLevel(w, t.Level); err != nil {
+			return err
+		}
+		out = gzw
+	}
+
+	tw := tar.NewWriter(out)
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     t.name(),
+		Mode:     int64(t.mode),
+		Size:     size,
+		ModTime:  t.modTime(),
+	}); err != nil {
+		return err
+	} else if _, err := io.Copy(tw, f); err != nil {
+		return err
+	} else if err := tw.Close(); err != nil {
+		return err
+	} else if gzw != nil {
+		return gzw.Close()
+	}
+	return nil
+}
+
+// Decode extracts the entry Name, or the first file if Name is empty,
+// of a tar archive.
+func (t *Tar) Decode(r io.Reader, w io.Writer) error {
+	in := r
+	if t.Gzip {
+		gzr, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gzr.Close()
+		in = gzr
+	}
+
+	tr := tar.NewReader(in)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return ErrEntryNotFound
+		} else if err != nil {
+			return err
+		} else if h.Typeflag != tar.TypeReg || (t.Name != "" && h.Name != t.Name) {
+			continue
+		}
+		if _, err := io.Copy(w, tr); err != nil {
+			return err
+		}
+		// read the end of the archive.
+		if _, err := io.Copy(ioutil.Discard, in); err != nil {
+			return err
+		}
+		_, err = io.Copy(ioutil.Discard, r)
+		return err
+	}
+}
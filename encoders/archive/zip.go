@@ -0,0 +1,91 @@
+package archive
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+
+	"github.com/hyperboloide/pipe/encoders"
+)
+
+// Zip wraps a stream as the single entry of a zip archive.
+// Decode extracts an entry of a zip archive, the directory of a zip
+// archive is at its end so Decode copies the whole stream in a temporary
+// file of TempDir first, it fails with ErrSpoolTooLarge after
+// MaxSpoolSize bytes. Encode does not use a temporary file.
+type Zip struct {
+	Entry
+
+	// Compression method, "deflate" (default) or "store".
+	Method string `json:"method"`
+
+	method uint16
+}
+
+// Start the Zip encoder.
+func (z *Zip) Start() error {
+	switch z.Method {
+	case "", "deflate":
+		z.Method, z.method = "deflate", zip.Deflate
+	case "store":
+		z.method = zip.Store
+	default:
+		return fmt.Errorf("invalid zip method '%s', should be 'deflate' or 'store'", z.Method)
+	}
+	return z.Entry.Start()
+}
+
+// Describe the Zip encoder in an envelope header.
+func (z *Zip) Describe() encoders.Layer {
+	return encoders.Layer{
+		Type:    "zip",
+		Version: 1,
+		Params:  map[string]interface{}{"name": z.name()},
+	}
+}
+
+// Encode the stream as an entry of a zip archive.
+func (z *Zip) Encode(r io.Reader, w io.Writer) error {
+	h := &zip.FileHeader{
+		Name:     z.name(),
+		Method:   z.method,
+		Modified: z.modTime(),
+	}
+	h.SetMode(z.mode)
+
+	zw := zip.NewWriter(w)
+	if ew, err := zw.CreateHeader(h); err != nil {
+		return err
+	} else if _, err := io.Copy(ew, r); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Decode extracts the entry Name, or the first file if Name is empty,
+// of a zip archive.
+func (z *Zip) Decode(r io.Reader, w io.Writer) error {
+	f, size, err := z.spool(r)
+	if err != nil {
+		return err
+	}
+	defer release(f)
+
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		return err
+	}
+	for _, entry := range zr.File {
+		if !entry.Mode().IsRegular() || (z.Name != "" && entry.Name != z.Name) {
+			continue
+		}
+		er, err := entry.Open()
+		if err != nil {
+			return err
+		}
+		defer er.Close()
+		_, err = io.Copy(w, er)
+		return err
+	}
+	return ErrEntryNotFound
+}
@@ -0,0 +1,30 @@
+package bzip2
+
+import (
+	"compress/bzip2"
+	"errors"
+	"io"
+)
+
+// ErrEncodeNotSupported is returned by Encode, the standard library
+// only decodes bzip2.
+var ErrEncodeNotSupported = errors.New("bzip2 encoding is not supported")
+
+// Bzip2 is a decoder of bzip2 streams, to read legacy archives.
+type Bzip2 struct{}
+
+// Start the Bzip2 decoder
+func (b *Bzip2) Start() error {
+	return nil
+}
+
+// Encode is not supported and returns ErrEncodeNotSupported.
+func (b *Bzip2) Encode(r io.Reader, w io.Writer) error {
+	return ErrEncodeNotSupported
+}
+
+// Decode a Bzip2 stream
+func (b *Bzip2) Decode(r io.Reader, w io.Writer) error {
+	_, err := io.Copy(w, bzip2.NewReader(r))
+	return err
+}
@@ -0,0 +1,37 @@
+package bzip2_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/hyperboloide/pipe/encoders/bzip2"
+)
+
+func TestBzip2(t *testing.T) {
+
+	dec := &bzip2.Bzip2{}
+	if err := dec.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	in, err := os.Open("../../tests/test.txt.bz2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+
+	var decoded bytes.Buffer
+	if err := dec.Decode(in, &decoded); err != nil {
+		t.Fatal(err)
+	} else if original, err := ioutil.ReadFile("../../tests/test.txt"); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(decoded.Bytes(), original) {
+		t.Error("the decoded bytes should match the original")
+	}
+
+	if err := dec.Encode(bytes.NewReader(nil), ioutil.Discard); err != bzip2.ErrEncodeNotSupported {
+		t.Error("encoding should not be supported")
+	}
+}
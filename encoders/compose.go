@@ -0,0 +1,47 @@
+package encoders
+
+import (
+	"io"
+
+	"github.com/hyperboloide/pipe"
+)
+
+// Composed is an EncoderDecoder made of several EncoderDecoders.
+// Encode applies the encoders in order and Decode applies the
+// decoders in reverse order.
+type Composed struct {
+	Encoders []EncoderDecoder
+}
+
+// Compose returns a Composed of encs.
+func Compose(encs ...EncoderDecoder) *Composed {
+	return &Composed{Encoders: encs}
+}
+
+// Start all the encoders.
+func (c *Composed) Start() error {
+	for _, e := range c.Encoders {
+		if err := e.Start(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Encode the stream with each encoder in order.
+func (c *Composed) Encode(r io.Reader, w io.Writer) error {
+	filters := make([]pipe.Filter, len(c.Encoders))
+	for i, e := range c.Encoders {
+		filters[i] = e.Encode
+	}
+	return pipe.Chain(filters...)(r, w)
+}
+
+// Decode the stream with each decoder in reverse order.
+func (c *Composed) Decode(r io.Reader, w io.Writer) error {
+	filters := make([]pipe.Filter, len(c.Encoders))
+	for i, e := range c.Encoders {
+		filters[len(c.Encoders)-1-i] = e.Decode
+	}
+	return pipe.Chain(filters...)(r, w)
+}
@@ -0,0 +1,23 @@
+package encoders_test
+
+import (
+	"testing"
+
+	"github.com/hyperboloide/pipe/encoders"
+	"github.com/hyperboloide/pipe/encoders/aes"
+	"github.com/hyperboloide/pipe/encoders/gzip"
+	"github.com/hyperboloide/pipe/tests"
+)
+
+func TestCompose(t *testing.T) {
+
+	k, err := aes.GenKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := encoders.Compose(&gzip.Gzip{}, &aes.AES{KeyB64: k})
+
+	if err := tests.TestEncoderDecoder(enc, "../tests/test.jpg"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,135 @@
+package encoders
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/hyperboloide/pipe"
+)
+
+const (
+	// MaxEnvelopeSize is the maximum size of the description of a Layer
+	// in an envelope header.
+	MaxEnvelopeSize = 64 * 1024
+	// MaxEnvelopeDepth is the maximum number of envelopes that AutoDecode
+	// decodes in a stream.
+	MaxEnvelopeDepth = 16
+)
+
+// EnvelopeMagic starts every envelope header.
+var EnvelopeMagic = []byte("PENV")
+
+// Layer describes how a stream was encoded in an envelope header.
+type Layer struct {
+	// Type of the encoder, like "gzip".
+	Type string `json:"type"`
+	// Version of the format of the encoder.
+	Version int `json:"version"`
+	// Parameters of the encoder, they must not contain secrets.
+	Params map[string]interface{} `json:"params,omitempty"`
+	// True if the encoder was applied with pipe.Parallel.
+	Parallel bool `json:"parallel,omitempty"`
+	// True if the input of the encoder started with another envelope.
+	Nested bool `json:"nested,omitempty"`
+}
+
+// Describer is implemented by encoders that can be described
+// in an envelope header.
+type Describer interface {
+	Describe() Layer
+}
+
+// WriteEnvelope writes an envelope header with the description of l:
+// EnvelopeMagic, the size of the description (uint32 big endian) and the
+// description in JSON.
+func WriteEnvelope(w io.Writer, l Layer) error {
+	js, err := json.Marshal(l)
+	if err != nil {
+		return err
+	} else if len(js) > MaxEnvelopeSize {
+		return errors.New("envelope header is too large")
+	}
+	header := make([]byte, len(EnvelopeMagic)+4, len(EnvelopeMagic)+4+len(js))
+	copy(header, EnvelopeMagic)
+	binary.BigEndian.PutUint32(header[len(EnvelopeMagic):], uint32(len(js)))
+	_, err = w.Write(append(header, js...))
+	return err
+}
+
+// ReadEnvelope reads an envelope header from r. If r does not start with
+// an envelope nothing is read and a nil Layer is returned.
+func ReadEnvelope(r *bufio.Reader) (*Layer, error) {
+	magic, err := r.Peek(len(EnvelopeMagic))
+	if err == io.EOF || (err == nil && !bytes.Equal(magic, EnvelopeMagic)) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, len(EnvelopeMagic)+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, errors.New("invalid envelope header")
+	}
+	size := binary.BigEndian.Uint32(header[len(EnvelopeMagic):])
+	if size > MaxEnvelopeSize {
+		return nil, errors.New("envelope header is too large")
+	}
+	js := make([]byte, size)
+	if _, err := io.ReadFull(r, js); err != nil {
+		return nil, errors.New("invalid envelope header")
+	}
+	l := &Layer{}
+	if err := json.Unmarshal(js, l); err != nil {
+		return nil, fmt.Errorf("invalid envelope header: %s", err)
+	}
+	return l, nil
+}
+
+// Envelope returns a Filter that writes an envelope header describing
+// the stream encoded by filter before its output.
+func Envelope(l Layer, filter pipe.Filter) pipe.Filter {
+	return func(r io.Reader, w io.Writer) error {
+		if err := WriteEnvelope(w, l); err != nil {
+			return err
+		}
+		return filter(r, w)
+	}
+}
+
+// AutoDecode returns a Filter that reads the envelope header of a stream
+// and decodes it with the filter returned by decoder for its Layer.
+// The output of a Nested layer must start with another envelope and is
+// decoded again, the output of the other layers is never read as an
+// envelope. A stream without envelope is copied without changes.
+func AutoDecode(decoder func(*Layer) (pipe.Filter, error)) pipe.Filter {
+	return autoDecode(decoder, MaxEnvelopeDepth, false)
+}
+
+func autoDecode(decoder func(*Layer) (pipe.Filter, error), depth int, nested bool) pipe.Filter {
+	return func(r io.Reader, w io.Writer) error {
+		br := bufio.NewReader(r)
+		l, err := ReadEnvelope(br)
+		if err != nil {
+			return err
+		} else if l == nil && nested {
+			return errors.New("nested envelope is missing")
+		} else if l == nil {
+			return pipe.Identity(br, w)
+		} else if depth == 0 {
+			return errors.New("too many nested envelopes")
+		}
+
+		filter, err := decoder(l)
+		if err != nil {
+			return err
+		} else if !l.Nested {
+			return filter(br, w)
+		}
+		return pipe.Chain(filter, autoDecode(decoder, depth-1, true))(br, w)
+	}
+}
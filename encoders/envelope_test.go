@@ -0,0 +1,103 @@
+package encoders_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/hyperboloide/pipe"
+	"github.com/hyperboloide/pipe/encoders"
+	"github.com/hyperboloide/pipe/encoders/aes"
+	"github.com/hyperboloide/pipe/encoders/gzip"
+)
+
+func TestEnvelope(t *testing.T) {
+	k, err := aes.GenKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz, enc := &gzip.Gzip{Level: 9}, &aes.AES{KeyB64: k}
+	if err := encoders.Compose(gz, enc).Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile("../tests/test.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var encoded bytes.Buffer
+	if err := pipe.New(bytes.NewReader(data)).Push(
+		encoders.Envelope(gz.Describe(), gz.Encode),
+		encoders.Envelope(nested(enc.Describe()), enc.Encode),
+	).To(&encoded).Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	var layers []string
+	auto := encoders.AutoDecode(func(l *encoders.Layer) (pipe.Filter, error) {
+		layers = append(layers, fmt.Sprintf("%s %v", l.Type, l.Params["level"]))
+		switch l.Type {
+		case "aes":
+			return enc.Decode, nil
+		case "gzip":
+			return gz.Decode, nil
+		}
+		return nil, errors.New("unknown layer")
+	})
+
+	var decoded bytes.Buffer
+	if err := pipe.New(&encoded).Push(auto).To(&decoded).Exec(); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(decoded.Bytes(), data) {
+		t.Error("decoded stream does not match the original")
+	} else if fmt.Sprint(layers) != "[aes <nil> gzip 9]" {
+		t.Errorf("invalid layers decoded %v", layers)
+	}
+
+	// streams without envelope are not modified.
+	decoded.Reset()
+	if err := pipe.New(bytes.NewReader(data)).Push(auto).To(&decoded).Exec(); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(decoded.Bytes(), data) {
+		t.Error("stream without envelope should not be modified")
+	}
+
+	// data that starts with an envelope is not decoded when the layer
+	// is not nested.
+	var inner bytes.Buffer
+	if err := encoders.WriteEnvelope(&inner, gz.Describe()); err != nil {
+		t.Fatal(err)
+	}
+	inner.Write(data)
+	encoded.Reset()
+	if err := pipe.New(bytes.NewReader(inner.Bytes())).Push(
+		encoders.Envelope(enc.Describe(), enc.Encode),
+	).To(&encoded).Exec(); err != nil {
+		t.Fatal(err)
+	}
+	decoded.Reset()
+	if err := pipe.New(&encoded).Push(auto).To(&decoded).Exec(); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(decoded.Bytes(), inner.Bytes()) {
+		t.Error("the output of a layer that is not nested should not be decoded")
+	}
+
+	// a nested layer must contain an envelope.
+	encoded.Reset()
+	if err := pipe.New(bytes.NewReader(data)).Push(
+		encoders.Envelope(nested(enc.Describe()), enc.Encode),
+	).To(&encoded).Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pipe.New(&encoded).Push(auto).To(ioutil.Discard).Exec(); err == nil {
+		t.Error("a nested layer without envelope should fail")
+	}
+}
+
+func nested(l encoders.Layer) encoders.Layer {
+	l.Nested = true
+	return l
+}
@@ -0,0 +1,52 @@
+package flate
+
+import (
+	"compress/flate"
+	"io"
+
+	"github.com/hyperboloide/pipe/encoders"
+)
+
+// Flate is an encoder that compress a stream in the raw deflate format,
+// without header nor checksum.
+type Flate struct {
+	// Compression level, see encoders.CompressionLevel.
+	Level int `json:"level"`
+}
+
+// Start the Flate encoder, returns an error if the level is not valid.
+func (f *Flate) Start() error {
+	level, err := encoders.CompressionLevel(f.Level)
+	f.Level = level
+	return err
+}
+
+// Describe the Flate encoder in an envelope header.
+func (f *Flate) Describe() encoders.Layer {
+	return encoders.Layer{
+		Type:    "flate",
+		Version: 1,
+		Params:  map[string]interface{}{"level": f.Level},
+	}
+}
+
+// Encode to a Flate stream
+func (f *Flate) Encode(r io.Reader, w io.Writer) error {
+	fw, err := flate.NewWriter(w, f.Level)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(fw, r); err != nil {
+		fw.Close()
+		return err
+	}
+	return fw.Close()
+}
+
+// Decode a Flate stream
+func (f *Flate) Decode(r io.Reader, w io.Writer) error {
+	fr := flate.NewReader(r)
+	defer fr.Close()
+	_, err := io.Copy(w, fr)
+	return err
+}
@@ -3,6 +3,8 @@ package gzip
 import (
 	"compress/gzip"
 	"io"
+
+	"github.com/hyperboloide/pipe/encoders"
 )
 
 // Gzip is an encoders that compress a stream
@@ -10,16 +12,21 @@ type Gzip struct {
 	Level int `json:"level"`
 }
 
-// Start the Gzip encoder
+// Start the Gzip encoder, returns an error if the level is not valid,
+// see encoders.CompressionLevel.
 func (g *Gzip) Start() error {
-	switch g.Level {
-	case gzip.DefaultCompression:
-	case gzip.BestCompression:
-	case gzip.BestSpeed:
-	default:
-		g.Level = gzip.DefaultCompression
+	level, err := encoders.CompressionLevel(g.Level)
+	g.Level = level
+	return err
+}
+
+// Describe the Gzip encoder in an envelope header.
+func (g *Gzip) Describe() encoders.Layer {
+	return encoders.Layer{
+		Type:    "gzip",
+		Version: 1,
+		Params:  map[string]interface{}{"level": g.Level},
 	}
-	return nil
 }
 
 // Encode to a Gzip stream
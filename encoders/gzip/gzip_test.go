@@ -18,4 +18,9 @@ func TestGzip(t *testing.T) {
 		t.Error(err)
 	}
 
+	for _, level := range []int{-3, 10} {
+		if err := (&gzip.Gzip{Level: level}).Start(); err == nil {
+			t.Errorf("level %d should not be valid", level)
+		}
+	}
 }
@@ -0,0 +1,137 @@
+package keyring
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// MaxIDSize is the maximum size of a key ID.
+const MaxIDSize = 255
+
+var (
+	// ErrUnknownKey is returned when a key ID is not in the Keyring.
+	ErrUnknownKey = errors.New("unknown key ID")
+)
+
+// Keyring is a set of named keys used by symmetric encoders. The active
+// key encrypts and its ID is written in the ciphertext so that any key of
+// the Keyring can decrypt. To rotate keys add a new key and make it active,
+// keep the old keys to read the data encrypted with them.
+type Keyring struct {
+	// Keys encoded in base64 by ID.
+	KeysB64 map[string]string `json:"keys"`
+
+	// ID of the key used to encrypt. Can be omitted if there is only one key.
+	Active string `json:"active"`
+
+	// Path of a JSON file with the keys and optionally the active key,
+	// in the same format: {"keys": {"id": "key"}, "active": "id"}.
+	// Keys defined inline take precedence.
+	File string `json:"key_file"`
+
+	keys map[string][]byte
+}
+
+// Start reads the key file if set and decodes the keys, each key must be
+// size bytes long.
+func (k *Keyring) Start(size int) error {
+	keys := map[string]string{}
+	active := k.Active
+
+	if k.File != "" {
+		js, err := ioutil.ReadFile(k.File)
+		if err != nil {
+			return err
+		}
+		tmp := struct {
+			Keys   map[string]string `json:"keys"`
+			Active string            `json:"active"`
+		}{}
+		if err := json.Unmarshal(js, &tmp); err != nil {
+			return fmt.Errorf("invalid key file: %s", err)
+		}
+		keys = tmp.Keys
+		if active == "" {
+			active = tmp.Active
+		}
+	}
+	for id, key := range k.KeysB64 {
+		if keys == nil {
+			keys = map[string]string{}
+		}
+		keys[id] = key
+	}
+
+	k.keys = map[string][]byte{}
+	for id, key := range keys {
+		if id == "" || len(id) > MaxIDSize {
+			return fmt.Errorf("key ID '%s' must be between 1 and %d bytes", id, MaxIDSize)
+		}
+		res, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return fmt.Errorf("key '%s': %s", id, err)
+		} else if len(res) != size {
+			return fmt.Errorf("key '%s' size must be %d bytes", id, size)
+		}
+		k.keys[id] = res
+		if active == "" && len(keys) == 1 {
+			active = id
+		}
+	}
+
+	if len(k.keys) == 0 {
+		return nil
+	} else if active == "" {
+		return errors.New("active key undefined for the keyring")
+	} else if _, ok := k.keys[active]; !ok {
+		return fmt.Errorf("active key '%s' is not in the keyring", active)
+	}
+	k.Active = active
+	return nil
+}
+
+// Enabled returns true if the Keyring has keys.
+func (k *Keyring) Enabled() bool {
+	return len(k.keys) > 0
+}
+
+// ActiveKey returns the ID and the key used to encrypt.
+func (k *Keyring) ActiveKey() (string, []byte) {
+	return k.Active, k.keys[k.Active]
+}
+
+// Key returns the key with ID id.
+func (k *Keyring) Key(id string) ([]byte, error) {
+	if key, ok := k.keys[id]; ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+// WriteID writes a key ID to w: its size on a byte and the ID.
+func WriteID(w io.Writer, id string) error {
+	if id == "" || len(id) > MaxIDSize {
+		return fmt.Errorf("key ID must be between 1 and %d bytes", MaxIDSize)
+	}
+	_, err := w.Write(append([]byte{byte(len(id))}, id...))
+	return err
+}
+
+// ReadID reads a key ID written with WriteID.
+func ReadID(r io.Reader) (string, error) {
+	size := []byte{0}
+	if _, err := io.ReadFull(r, size); err != nil {
+		return "", err
+	} else if size[0] == 0 {
+		return "", errors.New("invalid key ID")
+	}
+	id := make([]byte, size[0])
+	if _, err := io.ReadFull(r, id); err != nil {
+		return "", err
+	}
+	return string(id), nil
+}
@@ -0,0 +1,72 @@
+package keyring_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/hyperboloide/pipe/encoders/keyring"
+)
+
+const (
+	key1 = "l2CijrWFXB2qeKgxlsIqrypKylKWTLnDB8/Joujcjsw="
+	key2 = "JuLpA6M2yBO5ru0cMNaB0OqX1LjcRZXGYP7jsr7hXKE="
+)
+
+func TestKeyring(t *testing.T) {
+	k := &keyring.Keyring{}
+	if err := k.Start(32); err != nil {
+		t.Error(err)
+	} else if k.Enabled() {
+		t.Error("empty keyring should not be enabled")
+	}
+
+	k = &keyring.Keyring{KeysB64: map[string]string{"k1": key1}}
+	if err := k.Start(32); err != nil {
+		t.Error(err)
+	} else if id, _ := k.ActiveKey(); id != "k1" {
+		t.Errorf("the only key should be active, got '%s'", id)
+	}
+
+	k = &keyring.Keyring{KeysB64: map[string]string{"k1": key1, "k2": key2}}
+	if err := k.Start(32); err == nil {
+		t.Error("should require an active key")
+	}
+	k.Active = "k3"
+	if err := k.Start(32); err == nil {
+		t.Error("should require the active key in the keyring")
+	}
+	if err := k.Start(16); err == nil {
+		t.Error("should validate the size of the keys")
+	}
+
+	// keys from a file
+	f, err := ioutil.TempFile("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(`{"keys": {"k1": "` + key1 + `"}, "active": "k1"}`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	k = &keyring.Keyring{File: f.Name(), KeysB64: map[string]string{"k2": key2}, Active: "k2"}
+	if err := k.Start(32); err != nil {
+		t.Fatal(err)
+	} else if _, err := k.Key("k1"); err != nil {
+		t.Error(err)
+	} else if id, _ := k.ActiveKey(); id != "k2" {
+		t.Errorf("active key should be 'k2', got '%s'", id)
+	} else if _, err := k.Key("k3"); err != keyring.ErrUnknownKey {
+		t.Errorf("expected ErrUnknownKey, got %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := keyring.WriteID(&buf, "k1"); err != nil {
+		t.Fatal(err)
+	} else if id, err := keyring.ReadID(&buf); err != nil || id != "k1" {
+		t.Errorf("invalid ID '%s': %v", id, err)
+	}
+}
@@ -0,0 +1,147 @@
+package kms
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/hyperboloide/pipe/encoders"
+	"github.com/hyperboloide/pipe/encoders/aesgcm"
+	"github.com/hyperboloide/pipe/encoders/keyring"
+)
+
+const (
+	// Version of the stream format.
+	Version = 1
+	// MaxWrappedKeySize is the maximum size of a wrapped data key.
+	MaxWrappedKeySize = 4096
+)
+
+// Magic starts the streams encoded with KMS.
+var Magic = []byte("PKMS")
+
+// KMS encrypts each stream with a new random data key of 256 bits using
+// the aesgcm encoder. The data key is wrapped by a key encryption key of
+// a KeyProvider and stored at the start of the stream, so that rotating
+// or revoking a key encryption key does not require to encrypt the data
+// again.
+//
+// The output starts with Magic, the version, the ID of the key encryption
+// key (see keyring.WriteID), the size of the wrapped data key (uint16 big
+// endian) and the wrapped data key, followed by the aesgcm stream.
+type KMS struct {
+	// Provider of the key encryption keys. If nil it is created on Start
+	// from ProviderType.
+	Provider KeyProvider
+
+	// Type of the provider, "local" for a LocalProvider configured with
+	// Keyring, or "http" for an HTTPProvider configured with HTTP.
+	ProviderType string `json:"provider"`
+
+	// Keys of the local provider.
+	keyring.Keyring
+
+	// Configuration of the http provider.
+	HTTP HTTPProvider `json:"http"`
+
+	// Size of the segments of aesgcm, see aesgcm.AESGCM.
+	SegmentSize int `json:"segment_size"`
+}
+
+// Start the encoder.
+func (k *KMS) Start() error {
+	if k.Provider != nil {
+		return nil
+	}
+	switch k.ProviderType {
+	case "local":
+		p := &LocalProvider{k.Keyring}
+		if err := p.Start(); err != nil {
+			return err
+		}
+		k.Provider = p
+	case "http":
+		if err := k.HTTP.Start(); err != nil {
+			return err
+		}
+		k.Provider = &k.HTTP
+	case "":
+		return errors.New("key provider undefined for KMS")
+	default:
+		return fmt.Errorf("key provider '%s' is not supported", k.ProviderType)
+	}
+	return nil
+}
+
+// Describe the KMS encoder in an envelope header.
+func (k *KMS) Describe() encoders.Layer {
+	return encoders.Layer{Type: "kms", Version: Version}
+}
+
+// Encode generates a data key, wraps it with the provider and encrypts
+// the stream with it.
+func (k *KMS) Encode(r io.Reader, w io.Writer) error {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return err
+	}
+	id, wrapped, err := k.Provider.Wrap(dataKey)
+	if err != nil {
+		return err
+	} else if len(wrapped) > MaxWrappedKeySize {
+		return errors.New("wrapped data key is too large")
+	}
+
+	var header bytes.Buffer
+	header.Write(Magic)
+	header.WriteByte(Version)
+	if err := keyring.WriteID(&header, id); err != nil {
+		return err
+	}
+	binary.Write(&header, binary.BigEndian, uint16(len(wrapped)))
+	header.Write(wrapped)
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return err
+	}
+
+	enc := &aesgcm.AESGCM{Key: dataKey, SegmentSize: k.SegmentSize}
+	if err := enc.Start(); err != nil {
+		return err
+	}
+	return enc.Encode(r, w)
+}
+
+// Decode unwraps the data key with the provider and decrypts the stream.
+func (k *KMS) Decode(r io.Reader, w io.Writer) error {
+	header := make([]byte, len(Magic)+1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return errors.New("invalid kms header")
+	} else if !bytes.Equal(header[:len(Magic)], Magic) || header[len(Magic)] != Version {
+		return errors.New("invalid kms header")
+	}
+	id, err := keyring.ReadID(r)
+	if err != nil {
+		return errors.New("invalid kms header")
+	}
+	var size uint16
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil || size > MaxWrappedKeySize {
+		return errors.New("invalid kms header")
+	}
+	wrapped := make([]byte, size)
+	if _, err := io.ReadFull(r, wrapped); err != nil {
+		return errors.New("invalid kms header")
+	}
+
+	dataKey, err := k.Provider.Unwrap(id, wrapped)
+	if err != nil {
+		return err
+	}
+	dec := &aesgcm.AESGCM{Key: dataKey}
+	if err := dec.Start(); err != nil {
+		return err
+	}
+	return dec.Decode(r, w)
+}
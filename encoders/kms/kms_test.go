@@ -0,0 +1,97 @@
+package kms_test
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperboloide/pipe/encoders/aes"
+	"github.com/hyperboloide/pipe/encoders/keyring"
+	"github.com/hyperboloide/pipe/encoders/kms"
+	"github.com/hyperboloide/pipe/tests"
+)
+
+func TestKMSLocal(t *testing.T) {
+	enc := &kms.KMS{ProviderType: "local"}
+	if err := enc.Start(); err == nil {
+		t.Error(errors.New("should validate that the keys are present"))
+	}
+
+	k1, _ := aes.GenKey()
+	k2, _ := aes.GenKey()
+	enc = &kms.KMS{
+		ProviderType: "local",
+		Keyring:      keyring.Keyring{KeysB64: map[string]string{"k1": k1}},
+	}
+	if err := tests.TestEncoderDecoder(enc, "../../tests/test.jpg"); err != nil {
+		t.Error(err)
+	}
+
+	// rotate the key encryption key
+	rotated := &kms.KMS{
+		ProviderType: "local",
+		Keyring:      keyring.Keyring{KeysB64: map[string]string{"k1": k1, "k2": k2}, Active: "k2"},
+	}
+	if err := rotated.Start(); err != nil {
+		t.Fatal(err)
+	}
+	var encoded, decoded bytes.Buffer
+	if err := enc.Encode(strings.NewReader("some data"), &encoded); err != nil {
+		t.Fatal(err)
+	} else if err := rotated.Decode(&encoded, &decoded); err != nil {
+		t.Error(err)
+	} else if decoded.String() != "some data" {
+		t.Error(errors.New("decoded data do not match"))
+	}
+}
+
+func TestKMSHTTP(t *testing.T) {
+	k, _ := aes.GenKey()
+	local := &kms.LocalProvider{Keyring: keyring.Keyring{KeysB64: map[string]string{"k1": k}}}
+	if err := local.Start(); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(kms.Handler(local, "secret"))
+	defer srv.Close()
+
+	enc := &kms.KMS{
+		ProviderType: "http",
+		HTTP:         kms.HTTPProvider{URL: srv.URL, Token: "secret"},
+	}
+	if err := tests.TestEncoderDecoder(enc, "../../tests/test.jpg"); err != nil {
+		t.Error(err)
+	}
+
+	unauthorized := &kms.KMS{
+		ProviderType: "http",
+		HTTP:         kms.HTTPProvider{URL: srv.URL, Token: "invalid"},
+	}
+	if err := unauthorized.Start(); err != nil {
+		t.Fatal(err)
+	} else if err := unauthorized.Encode(strings.NewReader("some data"), &bytes.Buffer{}); err == nil {
+		t.Error(errors.New("should not wrap keys with an invalid token"))
+	}
+
+	// a KMS that does not reply times out.
+	release := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer hung.Close()
+	defer close(release)
+	timeout := &kms.KMS{
+		ProviderType: "http",
+		HTTP:         kms.HTTPProvider{URL: hung.URL, Timeout: 1},
+	}
+	if err := timeout.Start(); err != nil {
+		t.Fatal(err)
+	} else if timeout.HTTP.Client.Timeout != time.Second {
+		t.Errorf("invalid timeout %s", timeout.HTTP.Client.Timeout)
+	} else if err := timeout.Encode(strings.NewReader("some data"), &bytes.Buffer{}); err == nil {
+		t.Error(errors.New("should time out"))
+	}
+}
@@ -0,0 +1,215 @@
+package kms
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hyperboloide/pipe/encoders/keyring"
+)
+
+// KeyProvider wraps and unwraps data keys with key encryption keys (KEK).
+type KeyProvider interface {
+	// Wrap encrypts dataKey and returns the ID of the KEK used.
+	Wrap(dataKey []byte) (keyID string, wrapped []byte, err error)
+	// Unwrap decrypts a data key wrapped with the KEK keyID.
+	Unwrap(keyID string, wrapped []byte) ([]byte, error)
+}
+
+// LocalProvider is a KeyProvider that wraps data keys with the 256 bits
+// keys of a Keyring using aes 256 gcm. The active key wraps new data keys.
+// Use keyring.Keyring.File to keep the keys in a file, aes.GenKey
+// generates new keys.
+type LocalProvider struct {
+	keyring.Keyring
+}
+
+// Start the LocalProvider.
+func (l *LocalProvider) Start() error {
+	if err := l.Keyring.Start(32); err != nil {
+		return err
+	} else if !l.Keyring.Enabled() {
+		return errors.New("keys undefined for the local key provider")
+	}
+	return nil
+}
+
+func gcmFromKey(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Wrap encrypts dataKey with the active key. The result starts with
+// the nonce, the key ID is authenticated.
+func (l *LocalProvider) Wrap(dataKey []byte) (string, []byte, error) {
+	id, key := l.Keyring.ActiveKey()
+	gcm, err := gcmFromKey(key)
+	if err != nil {
+		return "", nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	return id, gcm.Seal(nonce, nonce, dataKey, []byte(id)), nil
+}
+
+// Unwrap decrypts a data key wrapped with the key keyID.
+func (l *LocalProvider) Unwrap(keyID string, wrapped []byte) ([]byte, error) {
+	key, err := l.Keyring.Key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := gcmFromKey(key)
+	if err != nil {
+		return nil, err
+	} else if len(wrapped) < gcm.NonceSize() {
+		return nil, errors.New("invalid wrapped key")
+	}
+	return gcm.Open(nil, wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():], []byte(keyID))
+}
+
+// wrapRequest is the body of the requests and responses of the HTTP KMS.
+type wrapRequest struct {
+	KeyID      string `json:"key_id,omitempty"`
+	Plaintext  []byte `json:"plaintext,omitempty"`
+	Ciphertext []byte `json:"ciphertext,omitempty"`
+}
+
+// DefaultHTTPTimeout is the timeout in seconds of the requests of an
+// HTTPProvider if Timeout is not set.
+const DefaultHTTPTimeout = 30
+
+// HTTPProvider is a KeyProvider that calls a KMS over HTTP:
+//
+//	POST {URL}/wrap   {"plaintext": "<b64>"} -> {"key_id": "...", "ciphertext": "<b64>"}
+//	POST {URL}/unwrap {"key_id": "...", "ciphertext": "<b64>"} -> {"plaintext": "<b64>"}
+//
+// Handler serves the same API, to run a local stand-in of the KMS.
+type HTTPProvider struct {
+	// Base URL of the KMS.
+	URL string `json:"url"`
+
+	// Bearer token sent in the Authorization header if set.
+	Token string `json:"token"`
+
+	// Timeout of the requests in seconds, defaults to DefaultHTTPTimeout.
+	// It is not used with Client.
+	Timeout int `json:"timeout"`
+
+	// Client used for the requests, defaults to a client with Timeout.
+	Client *http.Client
+}
+
+// Start the HTTPProvider.
+func (h *HTTPProvider) Start() error {
+	if h.URL == "" {
+		return errors.New("url undefined for the http key provider")
+	} else if h.Timeout < 0 {
+		return errors.New("timeout of the http key provider should be a positive number of seconds")
+	} else if h.Timeout == 0 {
+		h.Timeout = DefaultHTTPTimeout
+	}
+	if h.Client == nil {
+		h.Client = &http.Client{Timeout: time.Duration(h.Timeout) * time.Second}
+	}
+	h.URL = strings.TrimSuffix(h.URL, "/")
+	return nil
+}
+
+func (h *HTTPProvider) call(path string, req *wrapRequest) (*wrapRequest, error) {
+	js, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequest("POST", h.URL+path, bytes.NewReader(js))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if h.Token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+h.Token)
+	}
+
+	resp, err := h.Client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("key provider %s returned status %d", path, resp.StatusCode)
+	}
+	res := &wrapRequest{}
+	if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Wrap sends dataKey to the KMS to encrypt it.
+func (h *HTTPProvider) Wrap(dataKey []byte) (string, []byte, error) {
+	res, err := h.call("/wrap", &wrapRequest{Plaintext: dataKey})
+	if err != nil {
+		return "", nil, err
+	} else if res.KeyID == "" || len(res.Ciphertext) == 0 {
+		return "", nil, errors.New("invalid response of the key provider")
+	}
+	return res.KeyID, res.Ciphertext, nil
+}
+
+// Unwrap sends a wrapped data key to the KMS to decrypt it.
+func (h *HTTPProvider) Unwrap(keyID string, wrapped []byte) ([]byte, error) {
+	res, err := h.call("/unwrap", &wrapRequest{KeyID: keyID, Ciphertext: wrapped})
+	if err != nil {
+		return nil, err
+	}
+	return res.Plaintext, nil
+}
+
+// Handler returns an http.Handler that serves the API of HTTPProvider
+// with p. If token is not empty requests must send it as a bearer token.
+func Handler(p KeyProvider, token string) http.Handler {
+	handle := func(fn func(req *wrapRequest) (*wrapRequest, error)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			req := &wrapRequest{}
+			if r.Method != "POST" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			} else if token != "" && r.Header.Get("Authorization") != "Bearer "+token {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			} else if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			res, err := fn(req)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(res)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/wrap", handle(func(req *wrapRequest) (*wrapRequest, error) {
+		id, wrapped, err := p.Wrap(req.Plaintext)
+		return &wrapRequest{KeyID: id, Ciphertext: wrapped}, err
+	}))
+	mux.Handle("/unwrap", handle(func(req *wrapRequest) (*wrapRequest, error) {
+		key, err := p.Unwrap(req.KeyID, req.Ciphertext)
+		return &wrapRequest{Plaintext: key}, err
+	}))
+	return mux
+}
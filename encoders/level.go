@@ -0,0 +1,25 @@
+package encoders
+
+import (
+	"compress/flate"
+	"fmt"
+)
+
+// CompressionLevel validates a compression level of the flate based
+// encoders (flate, gzip and zlib) and returns the level to use.
+// Valid levels are flate.HuffmanOnly, flate.DefaultCompression and 1 to 9
+// (flate.BestSpeed to flate.BestCompression). 0 is the zero value of the
+// configuration and means flate.DefaultCompression.
+func CompressionLevel(level int) (int, error) {
+	switch {
+	case level == 0:
+		return flate.DefaultCompression, nil
+	case level == flate.HuffmanOnly, level == flate.DefaultCompression:
+		return level, nil
+	case level >= flate.BestSpeed && level <= flate.BestCompression:
+		return level, nil
+	}
+	return 0, fmt.Errorf(
+		"invalid compression level %d, should be between %d and %d, %d (default) or %d (huffman only)",
+		level, flate.BestSpeed, flate.BestCompression, flate.DefaultCompression, flate.HuffmanOnly)
+}
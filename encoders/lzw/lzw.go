@@ -0,0 +1,69 @@
+package lzw
+
+import (
+	"compress/lzw"
+	"fmt"
+	"io"
+
+	"github.com/hyperboloide/pipe/encoders"
+)
+
+// LZW is an encoder that compress a stream with the Lempel-Ziv-Welch
+// algorithm, as used in the GIF, TIFF and PDF formats.
+type LZW struct {
+	// Bit ordering, "lsb" (default, GIF) or "msb" (TIFF and PDF).
+	Order string `json:"order"`
+
+	// Number of bits of the literal codes, between 2 and 8.
+	// Defaults to 8.
+	LitWidth int `json:"lit_width"`
+
+	order lzw.Order
+}
+
+// Start the LZW encoder, returns an error if the order or the literal
+// width are not valid.
+func (l *LZW) Start() error {
+	switch l.Order {
+	case "", "lsb":
+		l.Order, l.order = "lsb", lzw.LSB
+	case "msb":
+		l.order = lzw.MSB
+	default:
+		return fmt.Errorf("invalid lzw order '%s', should be 'lsb' or 'msb'", l.Order)
+	}
+	if l.LitWidth == 0 {
+		l.LitWidth = 8
+	} else if l.LitWidth < 2 || l.LitWidth > 8 {
+		return fmt.Errorf("invalid lzw literal width %d, should be between 2 and 8", l.LitWidth)
+	}
+	return nil
+}
+
+// Describe the LZW encoder in an envelope header.
+func (l *LZW) Describe() encoders.Layer {
+	return encoders.Layer{
+		Type:    "lzw",
+		Version: 1,
+		Params:  map[string]interface{}{"order": l.Order, "lit_width": l.LitWidth},
+	}
+}
+
+// Encode to a LZW stream. With a literal width lower than 8 every byte
+// of the stream must be lower than 1<<LitWidth.
+func (l *LZW) Encode(r io.Reader, w io.Writer) error {
+	lw := lzw.NewWriter(w, l.order, l.LitWidth)
+	if _, err := io.Copy(lw, r); err != nil {
+		lw.Close()
+		return err
+	}
+	return lw.Close()
+}
+
+// Decode a LZW stream
+func (l *LZW) Decode(r io.Reader, w io.Writer) error {
+	lr := lzw.NewReader(r, l.order, l.LitWidth)
+	defer lr.Close()
+	_, err := io.Copy(w, lr)
+	return err
+}
@@ -0,0 +1,24 @@
+package lzw_test
+
+import (
+	"testing"
+
+	"github.com/hyperboloide/pipe/encoders/lzw"
+	"github.com/hyperboloide/pipe/tests"
+)
+
+func TestLZW(t *testing.T) {
+
+	for _, order := range []string{"", "msb"} {
+		enc := &lzw.LZW{Order: order}
+		if err := tests.TestEncoderDecoder(enc, "../../tests/test.jpg"); err != nil {
+			t.Errorf("order '%s': %s", order, err)
+		}
+	}
+
+	if err := (&lzw.LZW{Order: "invalid"}).Start(); err == nil {
+		t.Error("order should not be valid")
+	} else if err := (&lzw.LZW{LitWidth: 9}).Start(); err == nil {
+		t.Error("literal width should not be valid")
+	}
+}
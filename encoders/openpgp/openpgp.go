@@ -3,9 +3,24 @@ package openpgp
 import (
 	"errors"
 	"io"
+	"io/ioutil"
 	"os"
 
+	"github.com/hyperboloide/pipe/encoders"
 	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+// ArmorType is the type of the ASCII armored blocks.
+const ArmorType = "PGP MESSAGE"
+
+var (
+	// ErrNotSigned is returned by Decode if Verify is set and the
+	// message is not signed.
+	ErrNotSigned = errors.New("openpgp message is not signed")
+	// ErrUntrustedSigner is returned by Decode if Verify is set and the
+	// message is not signed by a trusted key.
+	ErrUntrustedSigner = errors.New("openpgp message is not signed by a trusted key")
 )
 
 // OpenPGP Encrypt and Decrypt with a key pair.
@@ -30,8 +45,41 @@ type OpenPGP struct {
 	// Will read the public key from a file if set.
 	PublicKeyPath string `json:"public_key"`
 
+	// Public key files of other recipients, the stream is encrypted
+	// for every key of PublicKey and PublicKeyPaths.
+	PublicKeyPaths []string `json:"public_keys"`
+
+	// Sign the stream with the private key when encrypting.
+	Sign bool `json:"sign"`
+
+	// Require a valid signature of a trusted key when decrypting.
+	// Note that the decrypted stream is written before the signature,
+	// at the end of the message, is verified: the pipe fails after.
+	Verify bool `json:"verify"`
+
+	// A reader to the public keys trusted to sign.
+	// If not set the public keys are trusted.
+	TrustedKeys io.Reader
+
+	// Will read the trusted keys from a file if set.
+	TrustedKeysPath string `json:"trusted_keys"`
+
+	// Encode to and decode from ASCII armored messages.
+	Armor bool `json:"armor"`
+
 	privateEntityList openpgp.EntityList
 	publicEntityList  openpgp.EntityList
+	trustedEntityList openpgp.EntityList
+}
+
+// readKeyRingFile reads a keyring from the file at path.
+func readKeyRingFile(path string) (openpgp.EntityList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return openpgp.ReadKeyRing(f)
 }
 
 // Start reads the keys and decrypt the private key if a PassPhrase is set.
@@ -68,7 +116,33 @@ func (o *OpenPGP) Start() error {
 			return err
 		}
 	}
-	return err
+
+	for _, path := range o.PublicKeyPaths {
+		el, err := readKeyRingFile(path)
+		if err != nil {
+			return err
+		}
+		o.publicEntityList = append(o.publicEntityList, el...)
+	}
+
+	if o.TrustedKeysPath != "" {
+		if o.trustedEntityList, err = readKeyRingFile(o.TrustedKeysPath); err != nil {
+			return err
+		}
+	} else if o.TrustedKeys != nil {
+		if o.trustedEntityList, err = openpgp.ReadKeyRing(o.TrustedKeys); err != nil {
+			return err
+		}
+	} else {
+		o.trustedEntityList = o.publicEntityList
+	}
+
+	if o.Sign && len(o.privateEntityList) == 0 {
+		return errors.New("a private key is required to sign with OpenPGP")
+	} else if o.Verify && len(o.trustedEntityList) == 0 {
+		return errors.New("trusted keys are required to verify with OpenPGP")
+	}
+	return nil
 }
 
 func (o *OpenPGP) loadPrivateKey() (err error) {
@@ -88,33 +162,103 @@ func (o *OpenPGP) loadPrivateKey() (err error) {
 	return
 }
 
-// Encode encrypts a stream with the public key.
+// Describe the OpenPGP encoder in an envelope header.
+func (o *OpenPGP) Describe() encoders.Layer {
+	return encoders.Layer{
+		Type:    "openpgp",
+		Version: 1,
+		Params:  map[string]interface{}{"armor": o.Armor},
+	}
+}
+
+// Encode encrypts a stream with the public keys and signs it with
+// the private key if Sign is set.
 func (o *OpenPGP) Encode(r io.Reader, w io.Writer) error {
 	if len(o.publicEntityList) == 0 {
 		return errors.New("no public key defined for OpenPGP")
 	}
 
-	wPGP, err := openpgp.Encrypt(w, o.publicEntityList, nil, nil, nil)
+	var signer *openpgp.Entity
+	if o.Sign {
+		signer = o.privateEntityList[0]
+	}
+
+	out := w
+	var armored io.WriteCloser
+	if o.Armor {
+		var err error
+		if armored, err = armor.Encode(w, ArmorType, nil); err != nil {
+			return err
+		}
+		out = armored
+	}
+
+	wPGP, err := openpgp.Encrypt(out, o.publicEntityList, signer, nil, nil)
 	if err != nil {
 		return err
 	}
-	defer wPGP.Close()
+	if _, err = io.Copy(wPGP, r); err != nil {
+		wPGP.Close()
+		return err
+	} else if err = wPGP.Close(); err != nil {
+		return err
+	} else if armored != nil {
+		return armored.Close()
+	}
+	return nil
+}
+
+// Decode decrypts with the private key and verifies the signature
+// if Verify is set.
+func (o *OpenPGP) Decode(r io.Reader, w io.Writer) error {
+	if !o.Armor {
+		return o.decode(r, w)
+	}
 
-	_, err = io.Copy(wPGP, r)
+	block, err := armor.Decode(r)
+	if err != nil {
+		return err
+	} else if block.Type != ArmorType {
+		return errors.New("invalid armored OpenPGP message type " + block.Type)
+	} else if err := o.decode(block.Body, w); err != nil {
+		return err
+	}
+	// read the end of the armored block to verify its checksum
+	// and consume the footer.
+	if _, err := io.Copy(ioutil.Discard, block.Body); err != nil {
+		return err
+	}
+	_, err = io.Copy(ioutil.Discard, r)
 	return err
 }
 
-// Decode decrypts with the private key
-func (o *OpenPGP) Decode(r io.Reader, w io.Writer) error {
+func (o *OpenPGP) decode(r io.Reader, w io.Writer) error {
 	if len(o.privateEntityList) == 0 {
 		return errors.New("no private key defined for OpenPGP")
 	}
 
-	md, err := openpgp.ReadMessage(r, o.privateEntityList, nil, nil)
+	keyring := o.privateEntityList
+	if o.Verify {
+		keyring = append(append(openpgp.EntityList{}, o.privateEntityList...), o.trustedEntityList...)
+	}
+	md, err := openpgp.ReadMessage(r, keyring, nil, nil)
 	if err != nil {
 		return err
 	}
 
-	_, err = io.Copy(w, md.UnverifiedBody)
-	return err
+	if o.Verify && !md.IsSigned {
+		return ErrNotSigned
+	} else if _, err = io.Copy(w, md.UnverifiedBody); err != nil {
+		return err
+	} else if !o.Verify {
+		return nil
+	}
+
+	// the signature is checked once the body is read.
+	if md.SignatureError != nil {
+		return md.SignatureError
+	} else if md.SignedBy == nil || len(o.trustedEntityList.KeysById(md.SignedByKeyId)) == 0 {
+		return ErrUntrustedSigner
+	}
+	return nil
 }
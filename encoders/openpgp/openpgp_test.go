@@ -1,10 +1,16 @@
 package openpgp_test
 
 import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/hyperboloide/pipe/encoders/openpgp"
 	"github.com/hyperboloide/pipe/tests"
+	xopenpgp "golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
 )
 
 func TestOpenPGP(t *testing.T) {
@@ -18,3 +24,132 @@ func TestOpenPGP(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestOpenPGPSignArmor(t *testing.T) {
+
+	enc := &openpgp.OpenPGP{
+		PrivateKeyPath: "./the_key.sec",
+		PublicKeyPath:  "./the_key.pub",
+		Sign:           true,
+		Verify:         true,
+		Armor:          true,
+	}
+
+	if err := tests.TestEncoderDecoder(enc, "../../tests/test.jpg"); err != nil {
+		t.Error(err)
+	}
+
+	var encoded bytes.Buffer
+	if err := enc.Encode(strings.NewReader("some data"), &encoded); err != nil {
+		t.Fatal(err)
+	} else if !strings.HasPrefix(encoded.String(), "-----BEGIN PGP MESSAGE-----") {
+		t.Error("message should be armored")
+	}
+}
+
+// newKey returns the serialized public and private keys of a new entity.
+func newKey(t *testing.T) (*bytes.Buffer, *bytes.Buffer) {
+	e, err := xopenpgp.NewEntity("Other", "For Testing", "other@mail.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// share the algorithms of the_key to encrypt for both.
+	for _, id := range e.Identities {
+		id.SelfSignature.PreferredSymmetric = []uint8{
+			uint8(packet.CipherAES256), uint8(packet.CipherAES192),
+			uint8(packet.CipherAES128), uint8(packet.CipherCAST5),
+		}
+		// SHA256 and SHA1 ids.
+		id.SelfSignature.PreferredHash = []uint8{8, 2}
+		if err := id.SelfSignature.SignUserId(id.UserId.Id, e.PrimaryKey, e.PrivateKey, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var pub, priv bytes.Buffer
+	if err := e.Serialize(&pub); err != nil {
+		t.Fatal(err)
+	} else if err := e.SerializePrivate(&priv, nil); err != nil {
+		t.Fatal(err)
+	}
+	return &pub, &priv
+}
+
+func TestOpenPGPVerify(t *testing.T) {
+	unsigned := &openpgp.OpenPGP{PublicKeyPath: "./the_key.pub"}
+	signed := &openpgp.OpenPGP{
+		PrivateKeyPath: "./the_key.sec",
+		PublicKeyPath:  "./the_key.pub",
+		Sign:           true,
+	}
+	verify := &openpgp.OpenPGP{
+		PrivateKeyPath: "./the_key.sec",
+		PublicKeyPath:  "./the_key.pub",
+		Verify:         true,
+	}
+	pub, _ := newKey(t)
+	untrusted := &openpgp.OpenPGP{
+		PrivateKeyPath: "./the_key.sec",
+		TrustedKeys:    pub,
+		Verify:         true,
+	}
+	for _, enc := range []*openpgp.OpenPGP{unsigned, signed, verify, untrusted} {
+		if err := enc.Start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		enc, dec *openpgp.OpenPGP
+		err      error
+	}{
+		{signed, verify, nil},
+		{unsigned, verify, openpgp.ErrNotSigned},
+		{signed, untrusted, openpgp.ErrUntrustedSigner},
+	}
+	for i, c := range cases {
+		var encoded bytes.Buffer
+		if err := c.enc.Encode(strings.NewReader("some data"), &encoded); err != nil {
+			t.Fatal(err)
+		} else if err := c.dec.Decode(&encoded, ioutil.Discard); err != c.err {
+			t.Errorf("case %d: expected error %v, got %v", i, c.err, err)
+		}
+	}
+}
+
+func TestOpenPGPRecipients(t *testing.T) {
+	pub, priv := newKey(t)
+	f, err := ioutil.TempFile("", "pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(pub.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	enc := &openpgp.OpenPGP{
+		PublicKeyPath:  "./the_key.pub",
+		PublicKeyPaths: []string{f.Name()},
+	}
+	first := &openpgp.OpenPGP{PrivateKeyPath: "./the_key.sec"}
+	other := &openpgp.OpenPGP{PrivateKey: priv}
+	for _, e := range []*openpgp.OpenPGP{enc, first, other} {
+		if err := e.Start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var encoded bytes.Buffer
+	if err := enc.Encode(strings.NewReader("some data"), &encoded); err != nil {
+		t.Fatal(err)
+	}
+	for _, dec := range []*openpgp.OpenPGP{first, other} {
+		var decoded bytes.Buffer
+		if err := dec.Decode(bytes.NewReader(encoded.Bytes()), &decoded); err != nil {
+			t.Error(err)
+		} else if decoded.String() != "some data" {
+			t.Error("decoded data do not match")
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package passphrase
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hyperboloide/pipe/encoders"
+	"github.com/hyperboloide/pipe/encoders/aesgcm"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// Version of the stream format.
+	Version = 1
+
+	// Scrypt key derivation function.
+	Scrypt = "scrypt"
+	// Argon2id key derivation function.
+	Argon2id = "argon2id"
+
+	// Default scrypt parameters.
+	DefaultScryptN = 1 << 15
+	DefaultScryptR = 8
+	DefaultScryptP = 1
+
+	// Default argon2id parameters, Memory is in KiB.
+	DefaultArgon2Time    = 1
+	DefaultArgon2Memory  = 64 * 1024
+	DefaultArgon2Threads = 4
+
+	// Limits of the parameters read from a stream header, to avoid
+	// exhausting resources when decoding. The memory limits are in bytes.
+	MaxScryptN       = 1 << 20
+	MaxScryptRP      = 1 << 10
+	MaxScryptMemory  = 256 << 20
+	MaxArgon2Time    = 16
+	MaxArgon2Memory  = 256 << 20
+	MaxArgon2Threads = 64
+)
+
+const (
+	saltSize = 16
+	keySize  = 32
+
+	// identifiers of the key derivation functions in the header.
+	scryptID   byte = 1
+	argon2idID byte = 2
+)
+
+// Magic starts the streams encoded with Passphrase.
+var Magic = []byte("PPHR")
+
+// Passphrase encrypts with a key derived from a passphrase with a memory
+// hard key derivation function (scrypt or argon2id) and a random salt.
+// The stream is encrypted with the aesgcm encoder.
+//
+// The output starts with Magic, the version, the key derivation function
+// and its parameters (uint32 big endian, threads of argon2id on a byte)
+// and the salt, followed by the aesgcm stream. The decoder reads the
+// parameters from the header so the costs can change over time.
+type Passphrase struct {
+	// The passphrase, prefer PassphraseEnv in configuration files.
+	Passphrase string `json:"passphrase"`
+
+	// Name of an environment variable that contains the passphrase,
+	// read on Start if Passphrase is empty.
+	PassphraseEnv string `json:"passphrase_env"`
+
+	// Key derivation function to encode, Scrypt (default) or Argon2id.
+	KDF string `json:"kdf"`
+
+	// Parameters of scrypt, N must be a power of 2.
+	N int `json:"n"`
+	R int `json:"r"`
+	P int `json:"p"`
+
+	// Parameters of argon2id, Memory is in KiB.
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+
+	// Size of the segments of aesgcm, see aesgcm.AESGCM.
+	SegmentSize int `json:"segment_size"`
+}
+
+// Start the encoder.
+func (p *Passphrase) Start() error {
+	if p.Passphrase == "" && p.PassphraseEnv != "" {
+		p.Passphrase = os.Getenv(p.PassphraseEnv)
+		if p.Passphrase == "" {
+			return fmt.Errorf("environment variable '%s' of the passphrase is empty", p.PassphraseEnv)
+		}
+	}
+	if p.Passphrase == "" {
+		return errors.New("passphrase undefined")
+	}
+
+	switch p.KDF {
+	case "", Scrypt:
+		p.KDF = Scrypt
+		if p.N == 0 {
+			p.N = DefaultScryptN
+		}
+		if p.R == 0 {
+			p.R = DefaultScryptR
+		}
+		if p.P == 0 {
+			p.P = DefaultScryptP
+		}
+		return validScrypt(p.N, p.R, p.P)
+	case Argon2id:
+		if p.Time == 0 {
+			p.Time = DefaultArgon2Time
+		}
+		if p.Memory == 0 {
+			p.Memory = DefaultArgon2Memory
+		}
+		if p.Threads == 0 {
+			p.Threads = DefaultArgon2Threads
+		}
+		return validArgon2(p.Time, p.Memory, p.Threads)
+	}
+	return fmt.Errorf("key derivation function '%s' is not supported", p.KDF)
+}
+
+func validScrypt(n, r, p int) error {
+	if n < 2 || n > MaxScryptN || n&(n-1) != 0 {
+		return fmt.Errorf("scrypt n must be a power of 2 between 2 and %d", MaxScryptN)
+	} else if r < 1 || p < 1 || r*p > MaxScryptRP {
+		return fmt.Errorf("scrypt r and p must be positive with r*p at most %d", MaxScryptRP)
+	} else if int64(n)*int64(r)*128 > MaxScryptMemory {
+		// scrypt allocates 128*n*r bytes.
+		return fmt.Errorf("scrypt memory 128*n*r must be at most %d bytes", MaxScryptMemory)
+	}
+	return nil
+}
+
+func validArgon2(time, memory uint32, threads uint8) error {
+	if time < 1 || time > MaxArgon2Time {
+		return fmt.Errorf("argon2id time must be between 1 and %d", MaxArgon2Time)
+	} else if memory < 8*uint32(threads) || int64(memory)*1024 > MaxArgon2Memory {
+		return fmt.Errorf("argon2id memory must be between 8*threads and %d KiB", MaxArgon2Memory/1024)
+	} else if threads < 1 || threads > MaxArgon2Threads {
+		return fmt.Errorf("argon2id threads must be between 1 and %d", MaxArgon2Threads)
+	}
+	return nil
+}
+
+// Describe the Passphrase encoder in an envelope header.
+// The parameters of the key derivation are part of the stream header.
+func (p *Passphrase) Describe() encoders.Layer {
+	return encoders.Layer{Type: "passphrase", Version: Version}
+}
+
+// Encode derives a key with a new salt and encrypts the stream with it.
+func (p *Passphrase) Encode(r io.Reader, w io.Writer) error {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+
+	var header bytes.Buffer
+	header.Write(Magic)
+	header.WriteByte(Version)
+	var key []byte
+	switch p.KDF {
+	case Scrypt:
+		header.WriteByte(scryptID)
+		binary.Write(&header, binary.BigEndian, []uint32{uint32(p.N), uint32(p.R), uint32(p.P)})
+		var err error
+		if key, err = scrypt.Key([]byte(p.Passphrase), salt, p.N, p.R, p.P, keySize); err != nil {
+			return err
+		}
+	case Argon2id:
+		header.WriteByte(argon2idID)
+		binary.Write(&header, binary.BigEndian, []uint32{p.Time, p.Memory})
+		header.WriteByte(p.Threads)
+		key = argon2.IDKey([]byte(p.Passphrase), salt, p.Time, p.Memory, p.Threads, keySize)
+	default:
+		return errors.New("passphrase encoder is not started")
+	}
+	header.Write(salt)
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return err
+	}
+
+	enc := &aesgcm.AESGCM{Key: key, SegmentSize: p.SegmentSize}
+	if err := enc.Start(); err != nil {
+		return err
+	}
+	return enc.Encode(r, w)
+}
+
+// deriveKey reads the header of the stream and derives the key.
+func (p *Passphrase) deriveKey(r io.Reader) ([]byte, error) {
+	invalid := errors.New("invalid passphrase header")
+
+	header := make([]byte, len(Magic)+2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, invalid
+	} else if !bytes.Equal(header[:len(Magic)], Magic) || header[len(Magic)] != Version {
+		return nil, invalid
+	}
+
+	var params []uint32
+	threads := []byte{0}
+	switch header[len(Magic)+1] {
+	case scryptID:
+		params = make([]uint32, 3)
+	case argon2idID:
+		params = make([]uint32, 2)
+	default:
+		return nil, invalid
+	}
+	if err := binary.Read(r, binary.BigEndian, params); err != nil {
+		return nil, invalid
+	} else if len(params) == 2 {
+		if _, err := io.ReadFull(r, threads); err != nil {
+			return nil, invalid
+		}
+	}
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(r, salt); err != nil {
+		return nil, invalid
+	}
+
+	if len(params) == 3 {
+		n, blockSize, parallel := int(params[0]), int(params[1]), int(params[2])
+		if err := validScrypt(n, blockSize, parallel); err != nil {
+			return nil, err
+		}
+		return scrypt.Key([]byte(p.Passphrase), salt, n, blockSize, parallel, keySize)
+	}
+	if err := validArgon2(params[0], params[1], threads[0]); err != nil {
+		return nil, err
+	}
+	return argon2.IDKey([]byte(p.Passphrase), salt, params[0], params[1], threads[0], keySize), nil
+}
+
+// Decode derives the key with the parameters of the stream header and
+// decrypts the stream. A wrong passphrase fails like a modified stream.
+func (p *Passphrase) Decode(r io.Reader, w io.Writer) error {
+	key, err := p.deriveKey(r)
+	if err != nil {
+		return err
+	}
+	dec := &aesgcm.AESGCM{Key: key}
+	if err := dec.Start(); err != nil {
+		return err
+	}
+	return dec.Decode(r, w)
+}
@@ -0,0 +1,88 @@
+package passphrase_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hyperboloide/pipe/encoders/aesgcm"
+	"github.com/hyperboloide/pipe/encoders/passphrase"
+	"github.com/hyperboloide/pipe/tests"
+)
+
+func TestPassphrase(t *testing.T) {
+	enc := &passphrase.Passphrase{}
+	if err := enc.Start(); err == nil {
+		t.Error(errors.New("should validate that the passphrase is present"))
+	}
+
+	enc = &passphrase.Passphrase{Passphrase: "secret", N: 1 << 10}
+	if err := tests.TestEncoderDecoder(enc, "../../tests/test.jpg"); err != nil {
+		t.Error(err)
+	}
+
+	os.Setenv("PIPE_TEST_PASSPHRASE", "secret")
+	defer os.Unsetenv("PIPE_TEST_PASSPHRASE")
+	enc = &passphrase.Passphrase{
+		PassphraseEnv: "PIPE_TEST_PASSPHRASE",
+		KDF:           passphrase.Argon2id,
+		Memory:        1024,
+		Threads:       1,
+	}
+	if err := tests.TestEncoderDecoder(enc, "../../tests/test.jpg"); err != nil {
+		t.Error(err)
+	}
+
+	// the parameters are read from the header.
+	var encoded, decoded bytes.Buffer
+	dec := &passphrase.Passphrase{Passphrase: "secret"}
+	if err := dec.Start(); err != nil {
+		t.Fatal(err)
+	} else if err := enc.Encode(strings.NewReader("some data"), &encoded); err != nil {
+		t.Fatal(err)
+	} else if err := dec.Decode(bytes.NewReader(encoded.Bytes()), &decoded); err != nil {
+		t.Error(err)
+	} else if decoded.String() != "some data" {
+		t.Error(errors.New("decoded data do not match"))
+	}
+
+	wrong := &passphrase.Passphrase{Passphrase: "wrong"}
+	if err := wrong.Start(); err != nil {
+		t.Fatal(err)
+	} else if err := wrong.Decode(bytes.NewReader(encoded.Bytes()), ioutil.Discard); err != aesgcm.ErrTruncated && err != aesgcm.ErrInvalidSegment {
+		t.Errorf("decoding with a wrong passphrase should fail, got %v", err)
+	}
+
+	// the memory of scrypt is limited, also when read from a header.
+	huge := &passphrase.Passphrase{Passphrase: "secret", N: 1 << 20, R: 8}
+	if err := huge.Start(); err == nil {
+		t.Error(errors.New("scrypt memory should be limited"))
+	}
+	// argon2id has the same memory limit, its memory is in KiB.
+	argon := &passphrase.Passphrase{Passphrase: "secret", KDF: passphrase.Argon2id, Memory: passphrase.MaxArgon2Memory / 1024}
+	if err := argon.Start(); err != nil {
+		t.Error(err)
+	}
+	argon = &passphrase.Passphrase{Passphrase: "secret", KDF: passphrase.Argon2id, Memory: passphrase.MaxArgon2Memory/1024 + 1}
+	if err := argon.Start(); err == nil {
+		t.Error(errors.New("argon2id memory should be limited"))
+	}
+	encoded.Reset()
+	scrypt := &passphrase.Passphrase{Passphrase: "secret", N: 1 << 10}
+	if err := scrypt.Start(); err != nil {
+		t.Fatal(err)
+	} else if err := scrypt.Encode(strings.NewReader("some data"), &encoded); err != nil {
+		t.Fatal(err)
+	}
+	crafted := encoded.Bytes()
+	params := crafted[len(passphrase.Magic)+2:]
+	binary.BigEndian.PutUint32(params, 1<<20)
+	binary.BigEndian.PutUint32(params[4:], 1024)
+	if err := scrypt.Decode(bytes.NewReader(crafted), ioutil.Discard); err == nil || !strings.Contains(err.Error(), "memory") {
+		t.Errorf("a header with a huge scrypt memory should be refused, got %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package zlib
+
+import (
+	"compress/zlib"
+	"io"
+
+	"github.com/hyperboloide/pipe/encoders"
+)
+
+// Zlib is an encoder that compress a stream in the zlib format.
+type Zlib struct {
+	// Compression level, see encoders.CompressionLevel.
+	Level int `json:"level"`
+}
+
+// Start the Zlib encoder, returns an error if the level is not valid.
+func (z *Zlib) Start() error {
+	level, err := encoders.CompressionLevel(z.Level)
+	z.Level = level
+	return err
+}
+
+// Describe the Zlib encoder in an envelope header.
+func (z *Zlib) Describe() encoders.Layer {
+	return encoders.Layer{
+		Type:    "zlib",
+		Version: 1,
+		Params:  map[string]interface{}{"level": z.Level},
+	}
+}
+
+// Encode to a Zlib stream
+func (z *Zlib) Encode(r io.Reader, w io.Writer) error {
+	zw, err := zlib.NewWriterLevel(w, z.Level)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(zw, r); err != nil {
+		zw.Close()
+		return err
+	}
+	return zw.Close()
+}
+
+// Decode a Zlib stream
+func (z *Zlib) Decode(r io.Reader, w io.Writer) error {
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	_, err = io.Copy(w, zr)
+	return err
+}
@@ -0,0 +1,24 @@
+package zlib_test
+
+import (
+	"testing"
+
+	"github.com/hyperboloide/pipe/encoders/zlib"
+	"github.com/hyperboloide/pipe/tests"
+)
+
+func TestZlib(t *testing.T) {
+
+	for _, level := range []int{0, -2, 1, 9} {
+		enc := &zlib.Zlib{Level: level}
+		if err := tests.TestEncoderDecoder(enc, "../../tests/test.jpg"); err != nil {
+			t.Errorf("level %d: %s", level, err)
+		}
+	}
+
+	for _, level := range []int{-3, 10} {
+		if err := (&zlib.Zlib{Level: level}).Start(); err == nil {
+			t.Errorf("level %d should not be valid", level)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+//
+// errors.go
+//
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of this source code package.
+//
+
+package pipe
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Default names of the stages that are not functions.
+const (
+	ReaderStage = "reader"
+	WriterStage = "writer"
+	TeeStage    = "tee"
+)
+
+// StageError is the error of a single stage of a Pipe.
+type StageError struct {
+	// Branch identifies the Pipe of the stage: empty for the main Pipe,
+	// "0" for its first tee, "0.1" for the second tee of that tee, etc.
+	Branch string
+	// Index of the stage in its Pipe, the reader is at index 0 and
+	// functions follow in the order they were pushed.
+	Index int
+	// Name of the stage if any.
+	Name string
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *StageError) Error() string {
+	var b strings.Builder
+	if e.Branch != "" {
+		fmt.Fprintf(&b, "tee %s ", e.Branch)
+	}
+	fmt.Fprintf(&b, "stage %d", e.Index)
+	if e.Name != "" {
+		fmt.Fprintf(&b, " (%s)", e.Name)
+	}
+	fmt.Fprintf(&b, ": %s", e.Err)
+	return b.String()
+}
+
+// Unwrap returns the underlying error.
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// Errors is returned by Exec and holds all the failures of a Pipe and its
+// tees. The first one is the error that interrupted the Pipe.
+// Elements are either a *StageError or the error of the context.
+type Errors []error
+
+func (e Errors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d errors: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the errors, so that errors.Is and errors.As
+// can be used on the result of Exec.
+func (e Errors) Unwrap() []error {
+	return e
+}
+
+// Stages returns the stage errors.
+func (e Errors) Stages() []*StageError {
+	var res []*StageError
+	for _, err := range e {
+		if se, ok := err.(*StageError); ok {
+			res = append(res, se)
+		}
+	}
+	return res
+}
@@ -0,0 +1,135 @@
+//
+// fanin.go
+//
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of this source code package.
+//
+
+package pipe
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// MergeChunkSize is the maximum size of the data of a frame created by Merge.
+const MergeChunkSize = 32 * 1024
+
+// ErrTruncatedMerge is returned by Split if the stream ends before
+// the end of all the parts.
+var ErrTruncatedMerge = errors.New("merged stream is truncated")
+
+// Concat creates a new Pipe that reads the readers sequentially.
+func Concat(readers ...io.Reader) *Pipe {
+	return New(io.MultiReader(readers...))
+}
+
+// Merge creates a new Pipe that reads the readers concurrently and
+// interleaves their content in a single stream of frames.
+// Each frame starts with the index of the reader and the size of the data,
+// both as 32 bits big endian integers, followed by the data.
+// A frame with no data marks the end of a reader.
+// Use Split to get the original streams back.
+func Merge(readers ...io.Reader) *Pipe {
+	r, w := io.Pipe()
+	p := New(r)
+
+	mw := &mergeWriter{w: w}
+	var wg sync.WaitGroup
+	wg.Add(len(readers))
+	for i, reader := range readers {
+		go func(part uint32, reader io.Reader) {
+			defer wg.Done()
+			if err := mw.copy(part, reader); err != nil {
+				w.CloseWithError(err)
+			}
+		}(uint32(i), reader)
+	}
+	go func() {
+		wg.Wait()
+		w.Close()
+	}()
+
+	// unblock the readers if the Pipe fails.
+	go func() {
+		select {
+		case <-p.group.aborted:
+			r.CloseWithError(p.group.cause())
+		case <-p.done:
+		}
+	}()
+	return p
+}
+
+// mergeWriter writes frames to w.
+type mergeWriter struct {
+	sync.Mutex
+	w io.Writer
+}
+
+func (m *mergeWriter) frame(part uint32, data []byte) error {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], part)
+	binary.BigEndian.PutUint32(header[4:], uint32(len(data)))
+
+	m.Lock()
+	defer m.Unlock()
+	if _, err := m.w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := m.w.Write(data)
+	return err
+}
+
+// copy writes the content of reader as frames of part.
+func (m *mergeWriter) copy(part uint32, reader io.Reader) error {
+	buf := make([]byte, MergeChunkSize)
+	for {
+		n, err := reader.Read(buf)
+		if n > 0 {
+			if werr := m.frame(part, buf[:n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return m.frame(part, nil)
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// Split reads a stream created by Merge and writes each part to the
+// writer with the same index.
+func Split(r io.Reader, parts ...io.Writer) error {
+	var header [8]byte
+	remaining := len(parts)
+	ended := make([]bool, len(parts))
+
+	for remaining > 0 {
+		if _, err := io.ReadFull(r, header[:]); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrTruncatedMerge
+		} else if err != nil {
+			return err
+		}
+		part := binary.BigEndian.Uint32(header[:4])
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+
+		if part >= uint32(len(parts)) {
+			return fmt.Errorf("merged stream has no writer for part %d", part)
+		} else if ended[part] {
+			return fmt.Errorf("part %d of merged stream has already ended", part)
+		} else if size == 0 {
+			ended[part] = true
+			remaining--
+		} else if n, err := io.CopyN(parts[part], r, size); err == io.EOF && n < size {
+			return ErrTruncatedMerge
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
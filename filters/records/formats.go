@@ -0,0 +1,173 @@
+package records
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// csvReader reads records from CSV, the first row is the header.
+type csvReader struct {
+	r      *csv.Reader
+	header []string
+}
+
+func (c *csvReader) Read() (Record, error) {
+	if c.header == nil {
+		header, err := c.r.Read()
+		if err != nil {
+			return nil, err
+		}
+		c.header = header
+	}
+	row, err := c.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	res := make(Record, len(row))
+	for i, v := range row {
+		res[i] = Field{Name: c.header[i], Value: v}
+	}
+	return res, nil
+}
+
+// csvWriter writes records in CSV. The header is the names of the fields
+// of the first record, the fields of the next records are written in
+// the same order and fields not in the header are ignored.
+type csvWriter struct {
+	w      *csv.Writer
+	header []string
+	row    []string
+}
+
+func (c *csvWriter) Write(r Record) error {
+	if c.header == nil {
+		c.header = make([]string, len(r))
+		for i, f := range r {
+			c.header[i] = f.Name
+		}
+		c.row = make([]string, len(r))
+		if err := c.w.Write(c.header); err != nil {
+			return err
+		}
+	}
+	for i, name := range c.header {
+		v, _ := r.Get(name)
+		c.row[i] = String(v)
+	}
+	return c.w.Write(c.row)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonReader reads records from JSON objects, one after the other
+// for JSON Lines or in an array.
+type jsonReader struct {
+	dec     *json.Decoder
+	array   bool
+	started bool
+}
+
+func (j *jsonReader) Read() (Record, error) {
+	if j.array && !j.started {
+		if err := j.delim('['); err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return nil, err
+		}
+		j.started = true
+	}
+	if j.array && !j.dec.More() {
+		if err := j.delim(']'); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	if err := j.delim('{'); err != nil {
+		return nil, err
+	}
+	var res Record
+	for j.dec.More() {
+		tok, err := j.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		var v interface{}
+		if err := j.dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		res = append(res, Field{Name: tok.(string), Value: v})
+	}
+	if err := j.delim('}'); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// delim reads the delimiter d.
+func (j *jsonReader) delim(d json.Delim) error {
+	tok, err := j.dec.Token()
+	if err != nil {
+		return err
+	} else if tok != d {
+		return fmt.Errorf("records: expected '%s' in JSON, got '%v'", d, tok)
+	}
+	return nil
+}
+
+// jsonWriter writes records as JSON objects, one per line for JSON Lines
+// or in an array.
+type jsonWriter struct {
+	w     io.Writer
+	array bool
+	count int
+	buf   []byte
+}
+
+func (j *jsonWriter) Write(r Record) error {
+	j.buf = j.buf[:0]
+	switch {
+	case !j.array:
+	case j.count == 0:
+		j.buf = append(j.buf, '[', '\n')
+	default:
+		j.buf = append(j.buf, ',', '\n')
+	}
+	j.buf = append(j.buf, '{')
+	for i, f := range r {
+		if i > 0 {
+			j.buf = append(j.buf, ',')
+		}
+		name, err := marshal(f.Name)
+		if err != nil {
+			return err
+		}
+		value, err := marshal(f.Value)
+		if err != nil {
+			return err
+		}
+		j.buf = append(append(append(j.buf, name...), ':'), value...)
+	}
+	j.buf = append(j.buf, '}')
+	if !j.array {
+		j.buf = append(j.buf, '\n')
+	}
+	j.count++
+	_, err := j.w.Write(j.buf)
+	return err
+}
+
+func (j *jsonWriter) Close() error {
+	if !j.array {
+		return nil
+	} else if j.count == 0 {
+		_, err := io.WriteString(j.w, "[]\n")
+		return err
+	}
+	_, err := io.WriteString(j.w, "\n]\n")
+	return err
+}
@@ -0,0 +1,71 @@
+package records
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Predicate compares the value of a field with Value. Op is one of
+// "eq", "ne", "lt", "lte", "gt", "gte" and "contains". Values are compared
+// as numbers when both are numbers and as strings otherwise. A record
+// without the field only matches "ne".
+type Predicate struct {
+	Field string      `json:"field"`
+	Op    string      `json:"op"`
+	Value interface{} `json:"value"`
+}
+
+// Validate returns an error if the predicate is invalid.
+func (p Predicate) Validate() error {
+	if p.Field == "" {
+		return fmt.Errorf("records predicate should define a field")
+	}
+	switch p.Op {
+	case "eq", "ne", "lt", "lte", "gt", "gte", "contains":
+		return nil
+	}
+	return fmt.Errorf("records predicate operator '%s' is not supported", p.Op)
+}
+
+// Match returns true if the record matches the predicate.
+func (p Predicate) Match(r Record) bool {
+	v, ok := r.Get(p.Field)
+	if !ok {
+		return p.Op == "ne"
+	}
+	a, b := String(v), String(p.Value)
+	if p.Op == "contains" {
+		return strings.Contains(a, b)
+	}
+
+	cmp := strings.Compare(a, b)
+	if fa, err := strconv.ParseFloat(a, 64); err == nil {
+		if fb, err := strconv.ParseFloat(b, 64); err == nil {
+			switch {
+			case fa < fb:
+				cmp = -1
+			case fa > fb:
+				cmp = 1
+			default:
+				cmp = 0
+			}
+		}
+	}
+
+	switch p.Op {
+	case "eq":
+		return cmp == 0
+	case "ne":
+		return cmp != 0
+	case "lt":
+		return cmp < 0
+	case "lte":
+		return cmp <= 0
+	case "gt":
+		return cmp > 0
+	case "gte":
+		return cmp >= 0
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+// Package records provides streaming Filters for tabular data: conversions
+// between CSV, TSV, JSON Lines and JSON arrays, field projection and
+// predicate filtering. Records are read and written one at a time so
+// the stream is never loaded in memory.
+package records
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/hyperboloide/pipe"
+)
+
+// Format is a format of records.
+type Format string
+
+// Supported formats. CSV and TSV streams start with a header row with
+// the names of the fields, JSON streams contain objects.
+const (
+	CSV   Format = "csv"
+	TSV   Format = "tsv"
+	JSONL Format = "jsonl"
+	JSON  Format = "json"
+)
+
+// Field is a named value of a Record. Values read from CSV are strings,
+// values read from JSON are decoded with json.Number for numbers.
+type Field struct {
+	Name  string
+	Value interface{}
+}
+
+// Record is an ordered list of fields.
+type Record []Field
+
+// Get returns the value of the field name and true if it exists.
+func (r Record) Get(name string) (interface{}, bool) {
+	for _, f := range r {
+		if f.Name == name {
+			return f.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Func transforms a Record, it returns false to remove the record.
+type Func func(Record) (Record, bool)
+
+// Reader reads records, Read returns io.EOF after the last record.
+type Reader interface {
+	Read() (Record, error)
+}
+
+// Writer writes records, Close must be called after the last record.
+type Writer interface {
+	Write(Record) error
+	Close() error
+}
+
+// NewReader returns a Reader for format.
+func NewReader(format Format, r io.Reader) (Reader, error) {
+	switch format {
+	case CSV, TSV:
+		cr := csv.NewReader(r)
+		if format == TSV {
+			cr.Comma = '\t'
+		}
+		return &csvReader{r: cr}, nil
+	case JSONL, JSON:
+		dec := json.NewDecoder(r)
+		dec.UseNumber()
+		return &jsonReader{dec: dec, array: format == JSON}, nil
+	}
+	return nil, fmt.Errorf("records format '%s' is not supported", format)
+}
+
+// NewWriter returns a Writer for format.
+func NewWriter(format Format, w io.Writer) (Writer, error) {
+	switch format {
+	case CSV, TSV:
+		cw := csv.NewWriter(w)
+		if format == TSV {
+			cw.Comma = '\t'
+		}
+		return &csvWriter{w: cw}, nil
+	case JSONL, JSON:
+		return &jsonWriter{w: w, array: format == JSON}, nil
+	}
+	return nil, fmt.Errorf("records format '%s' is not supported", format)
+}
+
+// Convert returns a Filter that reads records in the format from, applies
+// fns in order and writes the records in the format to.
+func Convert(from, to Format, fns ...Func) pipe.Filter {
+	return func(r io.Reader, w io.Writer) error {
+		rr, err := NewReader(from, r)
+		if err != nil {
+			return err
+		}
+		rw, err := NewWriter(to, w)
+		if err != nil {
+			return err
+		}
+		for {
+			rec, err := rr.Read()
+			if err == io.EOF {
+				return rw.Close()
+			} else if err != nil {
+				return err
+			}
+			keep := true
+			for _, fn := range fns {
+				if rec, keep = fn(rec); !keep {
+					break
+				}
+			}
+			if keep {
+				if err := rw.Write(rec); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// Project returns a Func that keeps only the fields names, in that order.
+// Missing fields are added with a nil value.
+func Project(names ...string) Func {
+	return func(r Record) (Record, bool) {
+		res := make(Record, len(names))
+		for i, name := range names {
+			v, _ := r.Get(name)
+			res[i] = Field{Name: name, Value: v}
+		}
+		return res, true
+	}
+}
+
+// Where returns a Func that keeps the records that match all predicates.
+func Where(predicates ...Predicate) Func {
+	return func(r Record) (Record, bool) {
+		for _, p := range predicates {
+			if !p.Match(r) {
+				return r, false
+			}
+		}
+		return r, true
+	}
+}
+
+// String returns the text representation of a value, as written in CSV.
+// nil is an empty string and objects and arrays are encoded in JSON.
+func String(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case json.Number:
+		return t.String()
+	case bool:
+		return strconv.FormatBool(t)
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	}
+	b, err := marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// marshal encodes v in JSON without escaping HTML characters.
+func marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
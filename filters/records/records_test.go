@@ -0,0 +1,116 @@
+package records_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/hyperboloide/pipe"
+	"github.com/hyperboloide/pipe/filters/records"
+)
+
+const people = "name,age,city\nalice,34,\"Paris, FR\"\nbob,27,London\ncarol,41,Berlin\n"
+
+func apply(f pipe.Filter, input string) (string, error) {
+	var res bytes.Buffer
+	if err := pipe.New(strings.NewReader(input)).Push(f).To(&res).Exec(); err != nil {
+		return "", err
+	}
+	return res.String(), nil
+}
+
+func TestConvert(t *testing.T) {
+	cases := []struct {
+		name     string
+		filter   pipe.Filter
+		input    string
+		expected string
+	}{
+		{
+			"csv to jsonl",
+			records.Convert(records.CSV, records.JSONL),
+			people,
+			`{"name":"alice","age":"34","city":"Paris, FR"}` + "\n" +
+				`{"name":"bob","age":"27","city":"London"}` + "\n" +
+				`{"name":"carol","age":"41","city":"Berlin"}` + "\n",
+		},
+		{
+			"csv to json with projection and predicate",
+			records.Convert(records.CSV, records.JSON,
+				records.Where(records.Predicate{Field: "age", Op: "gt", Value: 30}),
+				records.Project("name", "age")),
+			people,
+			"[\n" + `{"name":"alice","age":"34"},` + "\n" +
+				`{"name":"carol","age":"41"}` + "\n]\n",
+		},
+		{
+			"json to tsv",
+			records.Convert(records.JSON, records.TSV),
+			`[{"id": 1, "tags": ["a", "b"], "ok": true}, {"ok": false, "id": 2.5, "extra": null}]`,
+			"id\ttags\tok\n1\t\"[\"\"a\"\",\"\"b\"\"]\"\ttrue\n2.5\t\tfalse\n",
+		},
+		{
+			"jsonl to csv",
+			records.Convert(records.JSONL, records.CSV,
+				records.Where(records.Predicate{Field: "city", Op: "contains", Value: "on"})),
+			`{"city": "London", "n": 1}` + "\n" + `{"city": "Paris", "n": 2}` + "\n",
+			"city,n\nLondon,1\n",
+		},
+		{
+			"empty json array",
+			records.Convert(records.CSV, records.JSON,
+				records.Where(records.Predicate{Field: "name", Op: "eq", Value: "dave"})),
+			people,
+			"[]\n",
+		},
+	}
+
+	for _, c := range cases {
+		if res, err := apply(c.filter, c.input); err != nil {
+			t.Errorf("%s: %s", c.name, err)
+		} else if res != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.name, c.expected, res)
+		}
+	}
+}
+
+func TestConvertErrors(t *testing.T) {
+	inputs := map[records.Format]string{
+		records.CSV:   "a,b\n1,2,3\n",
+		records.JSON:  `{"a": 1}`,
+		records.JSONL: `{"a": 1} [2]`,
+	}
+	for format, input := range inputs {
+		if _, err := apply(records.Convert(format, records.JSONL), input); err == nil {
+			t.Errorf("%s: invalid input should fail", format)
+		}
+	}
+	if _, err := apply(records.Convert("xml", records.JSONL), people); err == nil {
+		t.Error("unsupported format should fail")
+	}
+}
+
+func TestPredicate(t *testing.T) {
+	r := records.Record{{Name: "n", Value: "9"}, {Name: "s", Value: "abc"}}
+	cases := []struct {
+		p        records.Predicate
+		expected bool
+	}{
+		{records.Predicate{Field: "n", Op: "lt", Value: 10}, true},
+		{records.Predicate{Field: "n", Op: "gte", Value: "10"}, false},
+		{records.Predicate{Field: "s", Op: "lt", Value: "b"}, true},
+		{records.Predicate{Field: "s", Op: "eq", Value: "abc"}, true},
+		{records.Predicate{Field: "x", Op: "eq", Value: ""}, false},
+		{records.Predicate{Field: "x", Op: "ne", Value: ""}, true},
+	}
+	for _, c := range cases {
+		if err := c.p.Validate(); err != nil {
+			t.Error(err)
+		} else if c.p.Match(r) != c.expected {
+			t.Errorf("%v should match: %v", c.p, c.expected)
+		}
+	}
+	if err := (records.Predicate{Field: "n", Op: "like"}).Validate(); err == nil {
+		t.Error("unsupported operator should fail")
+	}
+}
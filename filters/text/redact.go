@@ -0,0 +1,82 @@
+package text
+
+import (
+	"regexp"
+
+	"github.com/hyperboloide/pipe"
+)
+
+// DefaultMask replaces the redacted text if the mask of a Redaction is empty.
+const DefaultMask = "[REDACTED]"
+
+var (
+	// EmailPattern matches email addresses.
+	EmailPattern = regexp.MustCompile(`[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}`)
+	// CreditCardPattern matches 13 to 19 digits separated by spaces or dashes.
+	CreditCardPattern = regexp.MustCompile(`\b(?:\d[ \-]?){12,18}\d\b`)
+)
+
+// Redaction replaces the matches of Pattern by Mask.
+type Redaction struct {
+	Pattern *regexp.Regexp
+	// Replacement of the matches, can reference the groups of Pattern
+	// like regexp.Expand. Defaults to DefaultMask.
+	Mask string
+	// If set only the matches for which Valid returns true are replaced.
+	Valid func(match []byte) bool
+}
+
+// Emails returns a Redaction of email addresses.
+func Emails(mask string) Redaction {
+	return Redaction{Pattern: EmailPattern, Mask: mask}
+}
+
+// CreditCards returns a Redaction of credit card numbers, the numbers
+// are validated with the Luhn algorithm.
+func CreditCards(mask string) Redaction {
+	return Redaction{Pattern: CreditCardPattern, Mask: mask, Valid: Luhn}
+}
+
+// Luhn returns true if the digits of number have a valid Luhn checksum,
+// other characters are ignored.
+func Luhn(number []byte) bool {
+	sum, digits := 0, 0
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if digits%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+	}
+	return digits > 0 && sum%10 == 0
+}
+
+// Redact returns a Filter that applies the redactions in order on each line.
+func Redact(redactions []Redaction, maxLineSize int) pipe.Filter {
+	return Lines(maxLineSize, func(line []byte) ([]byte, bool) {
+		for _, red := range redactions {
+			mask := red.Mask
+			if mask == "" {
+				mask = DefaultMask
+			}
+			if red.Valid == nil {
+				line = red.Pattern.ReplaceAll(line, []byte(mask))
+				continue
+			}
+			line = red.Pattern.ReplaceAllFunc(line, func(match []byte) []byte {
+				if !red.Valid(match) {
+					return match
+				}
+				return red.Pattern.Expand(nil, []byte(mask), match, red.Pattern.FindSubmatchIndex(match))
+			})
+		}
+		return line, true
+	})
+}
@@ -0,0 +1,145 @@
+// Package text provides streaming Filters for text: regex replace,
+// redaction, line filtering and newline and encoding conversions.
+// Line based filters fail with ErrLineTooLong on lines longer than their
+// maximum line size.
+package text
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/hyperboloide/pipe"
+)
+
+// DefaultMaxLineSize is the maximum size of a line if 0 is used.
+const DefaultMaxLineSize = 64 * 1024
+
+// ErrLineTooLong is returned by the line based filters for a line longer
+// than their maximum line size. Such a line is not split: a match that
+// straddles the parts would be missed.
+var ErrLineTooLong = errors.New("line exceeds the maximum line size")
+
+// Lines returns a Filter that calls fn on each line of the stream without
+// its line ending ("\n" or "\r\n"). fn returns the new line and false
+// to remove the line. The line ending is written after the new line.
+// Lines of more than maxLineSize bytes (DefaultMaxLineSize if 0) with
+// their line ending fail with ErrLineTooLong.
+func Lines(maxLineSize int, fn func(line []byte) ([]byte, bool)) pipe.Filter {
+	if maxLineSize <= 0 {
+		maxLineSize = DefaultMaxLineSize
+	}
+	return func(r io.Reader, w io.Writer) error {
+		br := bufio.NewReaderSize(r, maxLineSize)
+		for {
+			line, err := br.ReadSlice('\n')
+			if err == bufio.ErrBufferFull {
+				return ErrLineTooLong
+			} else if err != nil && err != io.EOF {
+				return err
+			} else if len(line) > 0 {
+				content, ending := splitEnding(line)
+				if res, keep := fn(content); keep {
+					if _, werr := w.Write(res); werr != nil {
+						return werr
+					} else if _, werr := w.Write(ending); werr != nil {
+						return werr
+					}
+				}
+			}
+			if err == io.EOF {
+				return nil
+			}
+		}
+	}
+}
+
+// splitEnding returns the content of a line and its line ending.
+func splitEnding(line []byte) ([]byte, []byte) {
+	if bytes.HasSuffix(line, []byte("\r\n")) {
+		return line[:len(line)-2], line[len(line)-2:]
+	} else if bytes.HasSuffix(line, []byte("\n")) {
+		return line[:len(line)-1], line[len(line)-1:]
+	}
+	return line, nil
+}
+
+// Replace returns a Filter that replaces the matches of re in each line
+// by repl, repl can reference the groups of re like regexp.Expand.
+func Replace(re *regexp.Regexp, repl string, maxLineSize int) pipe.Filter {
+	return Lines(maxLineSize, func(line []byte) ([]byte, bool) {
+		return re.ReplaceAll(line, []byte(repl)), true
+	})
+}
+
+// Grep returns a Filter that keeps the lines that match re, or the lines
+// that do not match re if invert is true (like grep -v).
+func Grep(re *regexp.Regexp, invert bool, maxLineSize int) pipe.Filter {
+	return Lines(maxLineSize, func(line []byte) ([]byte, bool) {
+		return line, re.Match(line) != invert
+	})
+}
+
+// NormalizeNewlines returns a Filter that converts the line endings
+// "\r\n", "\r" and "\n" to ending.
+func NormalizeNewlines(ending string) pipe.Filter {
+	return func(r io.Reader, w io.Writer) error {
+		buf := make([]byte, 32*1024)
+		out := make([]byte, 0, 2*len(buf))
+		cr := false
+		for {
+			n, err := r.Read(buf)
+			out = out[:0]
+			for _, b := range buf[:n] {
+				switch {
+				case b == '\r':
+					out = append(out, ending...)
+				case b == '\n' && cr:
+				case b == '\n':
+					out = append(out, ending...)
+				default:
+					out = append(out, b)
+				}
+				cr = b == '\r'
+			}
+			if _, werr := w.Write(out); werr != nil {
+				return werr
+			}
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Latin1ToUTF8 is a Filter that converts a stream encoded in ISO 8859-1
+// (latin1) to UTF-8.
+func Latin1ToUTF8(r io.Reader, w io.Writer) error {
+	buf := make([]byte, 32*1024)
+	out := make([]byte, 0, 2*len(buf))
+	for {
+		n, err := r.Read(buf)
+		out = out[:0]
+		for _, b := range buf[:n] {
+			if b < utf8.RuneSelf {
+				out = append(out, b)
+			} else {
+				// latin1 code points are encoded on 2 bytes.
+				out = append(out, 0xc0|b>>6, 0x80|b&0x3f)
+			}
+		}
+		if _, werr := w.Write(out); werr != nil {
+			return werr
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
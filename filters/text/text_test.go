@@ -0,0 +1,89 @@
+package text_test
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/hyperboloide/pipe"
+	"github.com/hyperboloide/pipe/filters/text"
+)
+
+func apply(t *testing.T, f pipe.Filter, input string) string {
+	var res bytes.Buffer
+	if err := pipe.New(strings.NewReader(input)).Push(f).To(&res).Exec(); err != nil {
+		t.Fatal(err)
+	}
+	return res.String()
+}
+
+func TestText(t *testing.T) {
+	cases := []struct {
+		name     string
+		filter   pipe.Filter
+		input    string
+		expected string
+	}{
+		{
+			"replace",
+			text.Replace(regexp.MustCompile(`user=(\w+)`), "user=<$1>", 0),
+			"login user=bob\r\nlogout user=alice",
+			"login user=<bob>\r\nlogout user=<alice>",
+		},
+		{
+			"grep",
+			text.Grep(regexp.MustCompile(`^ERROR`), false, 0),
+			"ERROR a\nINFO b\nERROR c\n",
+			"ERROR a\nERROR c\n",
+		},
+		{
+			"grep -v",
+			text.Grep(regexp.MustCompile(`^DEBUG`), true, 0),
+			"DEBUG a\nINFO b\n",
+			"INFO b\n",
+		},
+		{
+			"redact",
+			text.Redact([]text.Redaction{text.Emails(""), text.CreditCards("****")}, 0),
+			"mail bob@example.com card 4111 1111 1111 1111 order 1234567890123\n",
+			"mail [REDACTED] card **** order 1234567890123\n",
+		},
+		{
+			"newlines",
+			text.NormalizeNewlines("\r\n"),
+			"a\nb\r\nc\rd",
+			"a\r\nb\r\nc\r\nd",
+		},
+		{
+			"latin1",
+			text.Latin1ToUTF8,
+			"caf\xe9",
+			"café",
+		},
+		{
+			"max line size",
+			text.Replace(regexp.MustCompile(`a`), "b", 16),
+			strings.Repeat(strings.Repeat("a", 15)+"\n", 4),
+			strings.Repeat(strings.Repeat("b", 15)+"\n", 4),
+		},
+	}
+
+	for _, c := range cases {
+		if res := apply(t, c.filter, c.input); res != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.name, c.expected, res)
+		}
+	}
+
+	// a match that straddles the maximum line size is not split in two
+	// parts that the filter would miss: the line is refused.
+	redact := text.Redact([]text.Redaction{text.Emails("")}, 16)
+	input := "0123456789 bob@example.com\n"
+	var res bytes.Buffer
+	if err := redact(strings.NewReader(input), &res); !errors.Is(err, text.ErrLineTooLong) {
+		t.Errorf("a long line should fail, got %v", err)
+	} else if strings.Contains(res.String(), "bob@") {
+		t.Errorf("the email should not be written, got %q", res.String())
+	}
+}
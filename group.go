@@ -0,0 +1,158 @@
+//
+// group.go
+//
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of this source code package.
+//
+
+package pipe
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// group holds every io.Pipe half created by a Pipe and its tees so that
+// they can all be closed at once when a stage fails or the context is done.
+// A tee that is allowed to fail has its own child group: the failure of
+// the parent interrupts the child but the failure of the child is handled
+// by onFail.
+type group struct {
+	sync.Mutex
+	errs     Errors
+	detached Errors
+	aborted  chan struct{}
+	readers  []*io.PipeReader
+	writers  []*io.PipeWriter
+
+	parent   *group
+	children []*group
+	onFail   func(error)
+}
+
+func newGroup() *group {
+	return &group{aborted: make(chan struct{})}
+}
+
+// child creates a new group interrupted when g fails.
+func (g *group) child(onFail func(error)) *group {
+	c := newGroup()
+	c.parent = g
+	c.onFail = onFail
+
+	g.Lock()
+	var err error
+	if len(g.errs) > 0 {
+		err = g.errs[0]
+	} else {
+		g.children = append(g.children, c)
+	}
+	g.Unlock()
+
+	if err != nil {
+		c.abort(err)
+	}
+	return c
+}
+
+// add registers the two halves of an io.Pipe. If the group was
+// already aborted they are closed immediately.
+func (g *group) add(r *io.PipeReader, w *io.PipeWriter) {
+	g.Lock()
+	defer g.Unlock()
+	if len(g.errs) > 0 {
+		r.CloseWithError(g.errs[0])
+		w.CloseWithError(g.errs[0])
+		return
+	}
+	g.readers = append(g.readers, r)
+	g.writers = append(g.writers, w)
+}
+
+// fail records err. The first error closes all the registered pipes,
+// later errors that are only a consequence of it are ignored.
+func (g *group) fail(err error) {
+	if g.abort(err) && g.onFail != nil {
+		g.onFail(err)
+	}
+}
+
+// abort records err and returns true if it's the first error of the group,
+// in which case the pipes of the group and of its children are closed.
+func (g *group) abort(err error) bool {
+	g.Lock()
+	if len(g.errs) > 0 {
+		if !errors.Is(err, g.errs[0]) && !errors.Is(err, io.ErrClosedPipe) {
+			g.errs = append(g.errs, err)
+		}
+		g.Unlock()
+		return false
+	}
+	g.errs = append(g.errs, err)
+	close(g.aborted)
+	for _, r := range g.readers {
+		r.CloseWithError(err)
+	}
+	for _, w := range g.writers {
+		w.CloseWithError(err)
+	}
+	children := g.children
+	g.Unlock()
+
+	for _, c := range children {
+		c.abort(err)
+	}
+	return true
+}
+
+// isAborted returns true if the group failed.
+func (g *group) isAborted() bool {
+	select {
+	case <-g.aborted:
+		return true
+	default:
+		return false
+	}
+}
+
+// cause returns the first error of the group or nil.
+func (g *group) cause() error {
+	g.Lock()
+	defer g.Unlock()
+	if len(g.errs) == 0 {
+		return nil
+	}
+	return g.errs[0]
+}
+
+// detach records the error of a child group that failed without
+// interrupting g. The error is also reported to the parents.
+func (g *group) detach(err error) {
+	g.Lock()
+	g.detached = append(g.detached, err)
+	g.Unlock()
+	if g.parent != nil {
+		g.parent.detach(err)
+	}
+}
+
+// errors returns the recorded errors or nil.
+func (g *group) errors() error {
+	g.Lock()
+	defer g.Unlock()
+	if len(g.errs) == 0 {
+		return nil
+	}
+	return append(Errors(nil), g.errs...)
+}
+
+// detachedErrors returns the errors of the detached children or nil.
+func (g *group) detachedErrors() Errors {
+	g.Lock()
+	defer g.Unlock()
+	if len(g.detached) == 0 {
+		return nil
+	}
+	return append(Errors(nil), g.detached...)
+}
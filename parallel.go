@@ -0,0 +1,152 @@
+//
+// parallel.go
+//
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of this source code package.
+//
+
+package pipe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"runtime"
+)
+
+// DefaultChunkSize is the size of the chunks of Parallel
+// if chunkSize is not set.
+const DefaultChunkSize = 1 << 20
+
+// MaxChunkSize is the maximum size of a chunk, before and after the filter.
+// ParallelDecode refuses larger chunks instead of allocating them.
+const MaxChunkSize = 64 << 20
+
+// Parallel returns a Filter that splits the stream in chunks of chunkSize
+// bytes and applies filter to each chunk independently, using up to workers
+// goroutines. The results are written in order, each one prefixed with its
+// size as a 32 bits big endian integer.
+// Use ParallelDecode with the inverse filter to read the stream back.
+// If workers is not set it defaults to the number of CPUs.
+func Parallel(filter Filter, workers, chunkSize int) Filter {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	return func(r io.Reader, w io.Writer) error {
+		if chunkSize > MaxChunkSize {
+			return ErrChunkTooLarge
+		}
+		next := func() ([]byte, error) {
+			buf := make([]byte, chunkSize)
+			n, err := io.ReadFull(r, buf)
+			if err == io.ErrUnexpectedEOF {
+				err = nil
+			}
+			return buf[:n], err
+		}
+		write := func(data []byte) error {
+			if len(data) > MaxChunkSize {
+				return ErrChunkTooLarge
+			}
+			var header [4]byte
+			binary.BigEndian.PutUint32(header[:], uint32(len(data)))
+			if _, err := w.Write(header[:]); err != nil {
+				return err
+			}
+			_, err := w.Write(data)
+			return err
+		}
+		return ordered(filter, workers, next, write)
+	}
+}
+
+// ParallelDecode returns a Filter that reads a stream created by Parallel
+// and applies filter to each chunk, using up to workers goroutines.
+// The results are written in order without any framing.
+// If workers is not set it defaults to the number of CPUs.
+func ParallelDecode(filter Filter, workers int) Filter {
+	return func(r io.Reader, w io.Writer) error {
+		next := func() ([]byte, error) {
+			var header [4]byte
+			if _, err := io.ReadFull(r, header[:]); err == io.ErrUnexpectedEOF {
+				return nil, errors.New("truncated chunk header in parallel stream")
+			} else if err != nil {
+				return nil, err
+			}
+			size := binary.BigEndian.Uint32(header[:])
+			if size > MaxChunkSize {
+				return nil, ErrChunkTooLarge
+			}
+			buf := make([]byte, size)
+			if _, err := io.ReadFull(r, buf); err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil, errors.New("truncated chunk in parallel stream")
+			} else if err != nil {
+				return nil, err
+			}
+			return buf, nil
+		}
+		write := func(data []byte) error {
+			_, err := w.Write(data)
+			return err
+		}
+		return ordered(filter, workers, next, write)
+	}
+}
+
+// ErrChunkTooLarge is returned for the chunks larger than MaxChunkSize.
+var ErrChunkTooLarge = errors.New("chunk of parallel stream exceeds the maximum size")
+
+// result of the filter for a chunk.
+type result struct {
+	data []byte
+	err  error
+}
+
+// ordered applies filter to the chunks returned by next until io.EOF and
+// passes the results to write in the same order.
+func ordered(filter Filter, workers int, next func() ([]byte, error), write func([]byte) error) error {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	queue := make(chan chan result, workers)
+	stop := make(chan struct{})
+
+	go func() {
+		defer close(queue)
+		for {
+			data, err := next()
+			if err == io.EOF {
+				return
+			}
+			res := make(chan result, 1)
+			select {
+			case queue <- res:
+			case <-stop:
+				return
+			}
+			if err != nil {
+				res <- result{err: err}
+				return
+			}
+			go func(data []byte) {
+				var buf bytes.Buffer
+				err := filter(bytes.NewReader(data), &buf)
+				res <- result{buf.Bytes(), err}
+			}(data)
+		}
+	}()
+
+	for res := range queue {
+		r := <-res
+		if r.err == nil {
+			r.err = write(r.data)
+		}
+		if r.err != nil {
+			close(stop)
+			return r.err
+		}
+	}
+	return nil
+}
@@ -13,7 +13,10 @@
 package pipe
 
 import (
+	"context"
 	"io"
+	"sync"
+	"time"
 )
 
 // Pipe object
@@ -23,10 +26,31 @@ type Pipe struct {
 	errors      []chan error
 	errorWriter chan error
 
+	ctx    context.Context
+	group  *group
+	branch string
+	tees   []*Pipe
+	done   chan struct{}
+	once   sync.Once
+	err    error
+
+	stages   []*stage
+	writer   *stage
+	start    time.Time
+	progress func(Stats)
+	quorum   quorum
+	digests  []*Digest
+
 	// Total number of bytes read at the origin of the Pipe.
+	// Set when Exec returns, use BytesIn while the Pipe is running.
 	TotalIn int64
 	// Total number of bytes written at the end of the Pipe.
+	// Set when Exec returns, use BytesOut while the Pipe is running.
 	TotalOut int64
+
+	// Interval between two calls of the function set with OnProgress.
+	// Defaults to DefaultProgressInterval.
+	ProgressInterval time.Duration
 }
 
 // Filter are functions to transform the stream
@@ -35,112 +59,213 @@ type Filter func(io.Reader, io.Writer) error
 
 // New create a new Pipe that reads from reader.
 func New(reader io.Reader) *Pipe {
+	return newPipe(context.Background(), newGroup(), "", reader)
+}
+
+// NewWithContext create a new Pipe that reads from reader.
+// If ctx is canceled before the end of the Pipe, every stage
+// is interrupted and Exec returns the context error.
+func NewWithContext(ctx context.Context, reader io.Reader) *Pipe {
+	return newPipe(ctx, newGroup(), "", reader)
+}
+
+func newPipe(ctx context.Context, g *group, branch string, reader io.Reader) *Pipe {
 	r, w := io.Pipe()
+	g.add(r, w)
 
 	p := &Pipe{
 		reader: r,
 		errors: make([]chan error, 1),
+		ctx:    ctx,
+		group:  g,
+		branch: branch,
+		done:   make(chan struct{}),
+		stages: []*stage{{name: ReaderStage}},
+		writer: &stage{name: WriterStage},
+		start:  time.Now(),
 	}
 	p.errors[0] = make(chan error, 1)
 	p.errorWriter = make(chan error, 1)
+	p.watch(ctx)
 
-	go func(errCh chan error) {
-		total, err := io.Copy(w, reader)
+	go func(st *stage, errCh chan error) {
+		_, err := io.Copy(&countWriter{w, st}, reader)
+		p.fail(0, ReaderStage, err)
 		w.Close()
-		p.TotalIn = total
 		errCh <- err
-	}(p.errors[0])
+	}(p.stages[0], p.errors[0])
 
 	return p
 }
 
+// watch aborts the Pipe when ctx is done, until the Pipe completes
+// or is interrupted.
+func (p *Pipe) watch(ctx context.Context) {
+	if ctx.Done() == nil {
+		return
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.group.fail(ctx.Err())
+		case <-p.done:
+		case <-p.group.aborted:
+		}
+	}()
+}
+
+// fail reports the error of a stage of the Pipe.
+func (p *Pipe) fail(index int, name string, err error) {
+	if err == nil {
+		return
+	}
+	p.group.fail(&StageError{
+		Branch: p.branch,
+		Index:  index,
+		Name:   name,
+		Err:    err,
+	})
+}
+
+// Context returns the context of the Pipe, see NewWithContext.
+func (p *Pipe) Context() context.Context {
+	return p.ctx
+}
+
 // Push appends a function to the Pipe.
 // Note that you can add as many functions as you like at once or
 // separatly. They will be processed in order.
 func (p *Pipe) Push(procs ...Filter) *Pipe {
 	for _, proc := range procs {
-		if proc == nil {
-			continue
-		}
+		p.PushNamed("", proc)
+	}
+	return p
+}
 
-		err := make(chan error, 1)
-		p.errors = append(p.errors, err)
+// PushNamed appends a function to the Pipe like Push, the name is
+// used to identify the function in a StageError.
+func (p *Pipe) PushNamed(name string, proc Filter) *Pipe {
+	if proc == nil {
+		return p
+	}
 
-		r, w := io.Pipe()
+	index := len(p.errors)
+	err := make(chan error, 1)
+	p.errors = append(p.errors, err)
+	st := &stage{name: name}
+	p.stages = append(p.stages, st)
 
-		go func(p Filter, r io.Reader, w *io.PipeWriter, err chan error) {
-			err <- p(r, w)
-			w.Close()
-		}(proc, p.reader, w, err)
+	r, w := io.Pipe()
+	p.group.add(r, w)
 
-		p.reader = r
-	}
+	go func(f Filter, r io.Reader, w *io.PipeWriter, errCh chan error) {
+		err := f(r, &countWriter{w, st})
+		p.fail(index, name, err)
+		w.Close()
+		errCh <- err
+	}(proc, p.reader, w, err)
+
+	p.reader = r
 	return p
 }
 
 // To writes the ouptut of the Pipe in w.
 func (p *Pipe) To(w io.Writer) *Pipe {
+	index := len(p.errors)
 	go func() {
-		total, err := io.Copy(w, p.reader)
-		p.TotalOut = total
+		_, err := io.Copy(w, &countReader{p.reader, p.writer})
+		p.fail(index, WriterStage, err)
 		p.errorWriter <- err
 	}()
 	return p
 }
 
+// Aborter is implemented by writers that can discard what was written,
+// for example to remove a partial file.
+type Aborter interface {
+	Abort() error
+}
+
 // ToCloser writes the ouptut of the Pipe in io.WriteCloser w and close at the end.
+// If the Pipe fails and w is an Aborter, Abort is called instead of Close.
 func (p *Pipe) ToCloser(w io.WriteCloser) *Pipe {
+	index := len(p.errors)
 	go func() {
-		total, err := io.Copy(w, p.reader)
-		p.TotalOut = total
+		_, err := io.Copy(w, &countReader{p.reader, p.writer})
 		if err == nil {
 			err = w.Close()
+		} else if a, ok := w.(Aborter); ok {
+			a.Abort()
 		}
+		p.fail(index, WriterStage, err)
 		p.errorWriter <- err
 	}()
 	return p
 }
 
 // Exec waits for the Pipe to complete and returns an error if any
-// of the functions failed.
+// of the functions failed. As soon as a function fails all the others
+// are interrupted.
+// The error returned is of type Errors, use errors.As to find the
+// failing StageError.
 func (p *Pipe) Exec() error {
+	return p.ExecContext(context.Background())
+}
+
+// ExecContext is like Exec but also interrupts the Pipe if ctx is done
+// before completion. In that case the context error is returned.
+// Exec and ExecContext also wait for the tees created from the Pipe,
+// calling them again (on the Pipe or on a tee) returns the same result.
+func (p *Pipe) ExecContext(ctx context.Context) error {
+	p.once.Do(func() {
+		p.err = p.exec(ctx)
+	})
+	return p.err
+}
+
+func (p *Pipe) exec(ctx context.Context) error {
+	defer close(p.done)
 	defer p.reader.Close()
+	defer p.setTotals()
+	p.watch(ctx)
+	if err := p.quorum.check(); err != nil {
+		p.group.fail(err)
+	}
+
+	if p.progress != nil {
+		done, stopped := make(chan struct{}), make(chan struct{})
+		go func() {
+			p.report(done)
+			close(stopped)
+		}()
+		defer func() {
+			close(done)
+			<-stopped
+			p.progress(p.Stats())
+		}()
+	}
 
 	for i := range p.errors {
-		if err := <-p.errors[i]; err != nil {
-			close(p.errors[i])
-			return err
+		select {
+		case <-p.errors[i]:
+		case <-p.group.aborted:
+			return p.group.errors()
 		}
-		close(p.errors[i])
+	}
+	select {
+	case <-p.errorWriter:
+	case <-p.group.aborted:
+		return p.group.errors()
 	}
 
-	defer close(p.errorWriter)
-	return <-p.errorWriter
+	for _, tee := range p.tees {
+		tee.ExecContext(ctx)
+	}
+	return p.group.errors()
 }
 
-// Tee creates a new Pipe to duplicate the stream.
-// The stream will pass through all previously pushed functions
-// before going through the tee Pipe.
-// Functions pushed to the original Pipe after a call to Tee will
-// not alter the new Tee Pipe.
-func (p *Pipe) Tee() *Pipe {
-	tR, tW := io.Pipe()
-
-	reader := io.TeeReader(p.reader, tW)
-	newR, newW := io.Pipe()
-
-	err := make(chan error, 1)
-	p.errors = append(p.errors, err)
-
-	go func(errCh chan error) {
-		_, err := io.Copy(newW, reader)
-		errCh <- err
-		newW.Close()
-		tW.Close()
-	}(err)
-
-	newPipe := New(tR)
-	p.reader = newR
-
-	return newPipe
+// setTotals sets TotalIn and TotalOut from the counters.
+func (p *Pipe) setTotals() {
+	p.TotalIn = p.BytesIn()
+	p.TotalOut = p.BytesOut()
 }
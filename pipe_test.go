@@ -12,12 +12,17 @@ import (
 	"bufio"
 	"bytes"
 	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"github.com/hyperboloide/pipe"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"testing"
+	"time"
 )
 
 func genBlob(size int) []byte {
@@ -115,6 +120,555 @@ func TestError(t *testing.T) {
 	}
 }
 
+func TestErrorInterrupts(t *testing.T) {
+	someErr := errors.New("some error!")
+
+	// fails without consuming its input, the other stages
+	// should not stay blocked.
+	var procErr = func(r io.Reader, w io.Writer) error {
+		return someErr
+	}
+
+	p := pipe.New(bytes.NewReader(bin)).Push(passProc, procErr, passProc)
+	p.To(ioutil.Discard)
+
+	if err := p.Exec(); !errors.Is(err, someErr) {
+		t.Errorf("pipe should return the filter error, got %v", err)
+	}
+}
+
+// blockingReader never returns until closed.
+type blockingReader chan struct{}
+
+func (b blockingReader) Read(p []byte) (int, error) {
+	<-b
+	return 0, io.EOF
+}
+
+func TestContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	block := make(blockingReader)
+	defer close(block)
+
+	p := pipe.NewWithContext(ctx, io.MultiReader(bytes.NewReader(bin), block))
+	p.Push(zip, unzip).To(ioutil.Discard)
+
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	if err := p.Exec(); !errors.Is(err, context.Canceled) {
+		t.Errorf("pipe should be canceled, got %v", err)
+	}
+}
+
+func TestExecContextTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	block := make(blockingReader)
+	defer close(block)
+
+	p := pipe.New(io.MultiReader(bytes.NewReader(bin), block))
+	pTee := p.Push(zip).Tee()
+	pTee.To(ioutil.Discard)
+	p.Push(unzip).To(ioutil.Discard)
+
+	if err := p.ExecContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("pipe should time out, got %v", err)
+	}
+	if err := pTee.Exec(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("tee should be interrupted too, got %v", err)
+	}
+}
+
+func TestStageError(t *testing.T) {
+	someErr := errors.New("some error!")
+	var procErr = func(r io.Reader, w io.Writer) error {
+		io.Copy(w, r)
+		return someErr
+	}
+
+	p := pipe.New(bytes.NewReader(bin)).Push(zip)
+	pTee := p.Tee()
+	pTee.PushNamed("unzip", unzip).PushNamed("broken", procErr)
+	pTee.To(ioutil.Discard)
+	p.Push(unzip).To(ioutil.Discard)
+
+	err := p.Exec()
+	var se *pipe.StageError
+	if !errors.As(err, &se) {
+		t.Fatalf("error should be a StageError, got %v", err)
+	}
+	if se.Branch != "0" || se.Index != 2 || se.Name != "broken" || se.Err != someErr {
+		t.Errorf("invalid stage error: %s", se)
+	}
+	if errs, ok := err.(pipe.Errors); !ok || len(errs.Stages()) != 1 {
+		t.Errorf("pipe should have exactly 1 failing stage: %v", err)
+	}
+}
+
+func TestWriterStageError(t *testing.T) {
+	p := pipe.New(bytes.NewReader(bin)).Push(passProc).ToCloser(&failCloser{})
+
+	var se *pipe.StageError
+	if err := p.Exec(); !errors.As(err, &se) {
+		t.Fatalf("error should be a StageError, got %v", err)
+	} else if se.Index != 2 || se.Name != pipe.WriterStage {
+		t.Errorf("writer should be the failing stage: %s", se)
+	}
+}
+
+type failCloser struct{}
+
+func (failCloser) Write(p []byte) (int, error) { return len(p), nil }
+func (failCloser) Close() error                { return errors.New("close failed") }
+
+// abortWriter sends "close" or "abort" on done.
+type abortWriter struct {
+	bytes.Buffer
+	done chan string
+}
+
+func (a *abortWriter) Close() error { a.done <- "close"; return nil }
+func (a *abortWriter) Abort() error { a.done <- "abort"; return nil }
+
+func TestAborter(t *testing.T) {
+	var procErr = func(r io.Reader, w io.Writer) error {
+		return errors.New("some error")
+	}
+	cases := []struct {
+		filter   pipe.Filter
+		expected string
+	}{
+		{passProc, "close"},
+		{procErr, "abort"},
+	}
+	for _, c := range cases {
+		w := &abortWriter{done: make(chan string, 1)}
+		pipe.New(bytes.NewReader(bin)).Push(c.filter).ToCloser(w).Exec()
+		select {
+		case res := <-w.done:
+			if res != c.expected {
+				t.Errorf("writer should %s, got %s", c.expected, res)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("writer should %s", c.expected)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	var calls int
+	var last pipe.Stats
+
+	p := pipe.New(bytes.NewReader(bin)).PushNamed("zip", zip)
+	pTee := p.Tee()
+	pTee.Push(unzip).To(ioutil.Discard)
+	p.To(ioutil.Discard)
+
+	p.ProgressInterval = time.Millisecond
+	p.OnProgress(func(s pipe.Stats) {
+		calls++
+		last = s
+	})
+
+	if err := p.Exec(); err != nil {
+		t.Fatalf("pipe should not have error %s", err)
+	}
+
+	if calls == 0 {
+		t.Errorf("progress function should be called")
+	}
+	if last.BytesIn != int64(len(bin)) || p.BytesIn() != p.TotalIn {
+		t.Errorf("invalid BytesIn %d", last.BytesIn)
+	}
+	if len(last.Stages) != 4 {
+		t.Fatalf("there should be 4 stages, got %d", len(last.Stages))
+	}
+	if zs := last.Stages[1]; zs.Name != "zip" || zs.BytesIn != int64(len(bin)) || zs.Ratio() >= 0.01 {
+		t.Errorf("invalid zip stats: %+v", zs)
+	}
+	if last.BytesOut != last.Stages[1].BytesOut {
+		t.Errorf("BytesOut should be the size of the zip: %d", last.BytesOut)
+	}
+	if len(last.Tees) != 1 || last.Tees[0].BytesOut != int64(len(bin)) {
+		t.Errorf("invalid tee stats: %+v", last.Tees)
+	}
+	if pTee.TotalOut != int64(len(bin)) {
+		t.Errorf("invalid tee TotalOut: %d", pTee.TotalOut)
+	}
+}
+
+func TestTeeBestEffort(t *testing.T) {
+	someErr := errors.New("some error!")
+	var procErr = func(r io.Reader, w io.Writer) error {
+		return someErr
+	}
+
+	// smaller than the buffer of the tees so that none is too slow.
+	data := bin[:pipe.TeeBufferSize/2]
+	p := pipe.New(bytes.NewReader(data))
+	p.TeeWithPolicy(pipe.BestEffort).Push(procErr).To(ioutil.Discard)
+	pTee := p.TeeWithPolicy(pipe.BestEffort)
+	pTee.To(ioutil.Discard)
+
+	var result bytes.Buffer
+	p.Push(zip, unzip).To(&result)
+
+	if err := p.Exec(); err != nil {
+		t.Fatalf("pipe should not have error %s", err)
+	}
+	if !bytes.Equal(result.Bytes(), data) {
+		t.Errorf("result do not match")
+	}
+	if errs := p.Detached(); len(errs) != 1 || !errors.Is(errs, someErr) {
+		t.Errorf("the failing tee should be detached: %v", errs)
+	}
+	if pTee.TotalOut != int64(len(data)) {
+		t.Errorf("the other tee should complete")
+	}
+
+	// a slow tee does not block the pipe, it is detached.
+	blob := genBlob(2 * pipe.TeeBufferSize)
+	release := make(chan struct{})
+	defer close(release)
+	var slow = func(r io.Reader, w io.Writer) error {
+		<-release
+		_, err := io.Copy(w, r)
+		return err
+	}
+	p = pipe.New(bytes.NewReader(blob))
+	p.TeeWithPolicy(pipe.BestEffort).Push(slow).To(ioutil.Discard)
+	p.To(ioutil.Discard)
+	done := make(chan error, 1)
+	go func() { done <- p.Exec() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("pipe should not have error %s", err)
+		} else if p.TotalOut != int64(len(blob)) {
+			t.Errorf("invalid TotalOut: %d", p.TotalOut)
+		} else if errs := p.Detached(); len(errs) != 1 || !errors.Is(errs, pipe.ErrSlowTee) {
+			t.Errorf("the slow tee should be detached: %v", errs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the slow tee should not block the pipe")
+	}
+}
+
+func TestTeeQuorum(t *testing.T) {
+	someErr := errors.New("some error!")
+	var procErr = func(r io.Reader, w io.Writer) error {
+		io.Copy(w, io.LimitReader(r, 1024))
+		return someErr
+	}
+
+	// smaller than the buffer of the tees so that none is too slow.
+	data := bin[:pipe.TeeBufferSize/2]
+	run := func(n, failures int) error {
+		p := pipe.New(bytes.NewReader(data))
+		for i := 0; i < 3; i++ {
+			tee := p.TeeWithPolicy(pipe.Quorum(n))
+			if i < failures {
+				tee.Push(procErr)
+			}
+			tee.To(ioutil.Discard)
+		}
+		return p.To(ioutil.Discard).Exec()
+	}
+
+	if err := run(2, 1); err != nil {
+		t.Errorf("quorum should be reached: %s", err)
+	}
+	if err := run(2, 2); !errors.Is(err, someErr) {
+		t.Errorf("quorum should be lost, got %v", err)
+	}
+	for _, n := range []int{0, 4} {
+		if err := run(n, 0); err == nil {
+			t.Errorf("quorum of %d with 3 tees should fail", n)
+		}
+	}
+
+	// a slow tee does not block the pipe, it is detached.
+	blob := genBlob(2 * pipe.TeeBufferSize)
+	release := make(chan struct{})
+	defer close(release)
+	var slow = func(r io.Reader, w io.Writer) error {
+		<-release
+		_, err := io.Copy(w, r)
+		return err
+	}
+	p := pipe.New(bytes.NewReader(blob))
+	p.TeeWithPolicy(pipe.Quorum(1)).Push(slow).To(ioutil.Discard)
+	p.TeeWithPolicy(pipe.Quorum(1)).To(ioutil.Discard)
+	p.To(ioutil.Discard)
+	done := make(chan error, 1)
+	go func() { done <- p.Exec() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("pipe should not have error %s", err)
+		} else if errs := p.Detached(); len(errs) != 1 || !errors.Is(errs, pipe.ErrSlowTee) {
+			t.Errorf("the slow tee should be detached: %v", errs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the slow tee should not block the pipe")
+	}
+}
+
+func TestConcat(t *testing.T) {
+	var result bytes.Buffer
+	p := pipe.Concat(bytes.NewReader(bin), bytes.NewReader(bin)).To(&result)
+
+	if err := p.Exec(); err != nil {
+		t.Fatalf("pipe should not have error %s", err)
+	}
+	if !bytes.Equal(result.Bytes(), append(append([]byte{}, bin...), bin...)) {
+		t.Errorf("result do not match")
+	}
+	if p.TotalIn != int64(2*len(bin)) {
+		t.Errorf("TotalIn do not match: %d", p.TotalIn)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	other := bytes.Repeat([]byte("0123456789"), 100000)
+
+	var merged bytes.Buffer
+	if err := pipe.Merge(bytes.NewReader(bin), bytes.NewReader(other)).Push(zip).To(&merged).Exec(); err != nil {
+		t.Fatalf("pipe should not have error %s", err)
+	}
+
+	var part0, part1 bytes.Buffer
+	var split = func(r io.Reader, w io.Writer) error {
+		return pipe.Split(r, &part0, &part1)
+	}
+	if err := pipe.New(&merged).Push(unzip, split).To(ioutil.Discard).Exec(); err != nil {
+		t.Fatalf("pipe should not have error %s", err)
+	}
+	if !bytes.Equal(part0.Bytes(), bin) || !bytes.Equal(part1.Bytes(), other) {
+		t.Errorf("parts do not match the originals")
+	}
+
+	var truncated bytes.Buffer
+	pipe.Merge(bytes.NewReader(other)).To(&truncated).Exec()
+	truncated.Truncate(truncated.Len() - 100)
+	if err := pipe.Split(&truncated, ioutil.Discard); err != pipe.ErrTruncatedMerge {
+		t.Errorf("split should detect truncation, got %v", err)
+	}
+}
+
+func TestParallel(t *testing.T) {
+	var encoded bytes.Buffer
+	p := pipe.New(bytes.NewReader(bin)).Push(pipe.Parallel(zip, 4, 1<<20)).To(&encoded)
+	if err := p.Exec(); err != nil {
+		t.Fatalf("pipe should not have error %s", err)
+	}
+
+	var result bytes.Buffer
+	p = pipe.New(&encoded).Push(pipe.ParallelDecode(unzip, 4)).To(&result)
+	if err := p.Exec(); err != nil {
+		t.Fatalf("pipe should not have error %s", err)
+	}
+	if !bytes.Equal(result.Bytes(), bin) {
+		t.Errorf("result do not match")
+	}
+
+	someErr := errors.New("some error!")
+	var procErr = func(r io.Reader, w io.Writer) error {
+		return someErr
+	}
+	p = pipe.New(bytes.NewReader(bin)).Push(pipe.Parallel(procErr, 4, 1024)).To(ioutil.Discard)
+	if err := p.Exec(); !errors.Is(err, someErr) {
+		t.Errorf("pipe should return the filter error, got %v", err)
+	}
+
+	// the size of a chunk is read from the stream and is limited.
+	malformed := []byte{0xff, 0xff, 0xff, 0xff, 0}
+	p = pipe.New(bytes.NewReader(malformed)).Push(pipe.ParallelDecode(unzip, 4)).To(ioutil.Discard)
+	if err := p.Exec(); !errors.Is(err, pipe.ErrChunkTooLarge) {
+		t.Errorf("pipe should refuse a chunk too large, got %v", err)
+	}
+	p = pipe.New(bytes.NewReader(bin)).Push(pipe.Parallel(zip, 4, pipe.MaxChunkSize+1)).To(ioutil.Discard)
+	if err := p.Exec(); !errors.Is(err, pipe.ErrChunkTooLarge) {
+		t.Errorf("pipe should refuse a chunk size too large, got %v", err)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	blob := genBlob(64 * 1024)
+
+	start := time.Now()
+	p := pipe.New(bytes.NewReader(blob)).Push(pipe.RateLimit(128*1024, 16*1024))
+	if err := p.To(ioutil.Discard).Exec(); err != nil {
+		t.Fatalf("pipe should not have error %s", err)
+	} else if p.TotalOut != int64(len(blob)) {
+		t.Errorf("TotalOut do not match")
+	} else if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("pipe is too fast: %s", elapsed)
+	}
+
+	// two pipes sharing the same limiter
+	l := pipe.NewLimiter(128*1024, 16*1024)
+	start = time.Now()
+	p1 := pipe.New(bytes.NewReader(blob[:32*1024])).Push(l.Filter()).To(ioutil.Discard)
+	p2 := pipe.New(bytes.NewReader(blob[:32*1024])).Push(l.Filter()).To(ioutil.Discard)
+	errs := make(chan error, 2)
+	go func() { errs <- p1.Exec() }()
+	go func() { errs <- p2.Exec() }()
+	if err := <-errs; err != nil {
+		t.Fatalf("pipe should not have error %s", err)
+	} else if err := <-errs; err != nil {
+		t.Fatalf("pipe should not have error %s", err)
+	} else if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("pipes are too fast: %s", elapsed)
+	}
+
+	// waiting stops with the context.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	slow := pipe.NewLimiter(1024, 1024).FilterContext(ctx)
+	if err := slow(bytes.NewReader(blob), ioutil.Discard); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("limiter should return the context error, got %v", err)
+	} else if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("limiter should stop with the context: %s", elapsed)
+	}
+
+	// without a rate the limiter is unlimited.
+	start = time.Now()
+	if err := pipe.RateLimit(0, 0)(bytes.NewReader(blob), ioutil.Discard); err != nil {
+		t.Fatal(err)
+	} else if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("limiter should be unlimited: %s", elapsed)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	sum := sha256.Sum256(bin)
+	expected := hex.EncodeToString(sum[:])
+
+	d, err := pipe.NewDigest(pipe.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := pipe.New(bytes.NewReader(bin)).PushDigest(d).Push(zip).To(ioutil.Discard)
+	if err := p.Exec(); err != nil {
+		t.Fatalf("pipe should not have error %s", err)
+	} else if d.Hex() != expected || p.Digests()[0] != d {
+		t.Errorf("invalid digest %s", d.Hex())
+	}
+
+	for _, algo := range []string{pipe.MD5, pipe.SHA1, pipe.SHA512, pipe.CRC32C} {
+		if d, err := pipe.NewDigest(algo); err != nil {
+			t.Error(err)
+		} else if err := pipe.New(bytes.NewReader(bin)).PushDigest(d).To(ioutil.Discard).Exec(); err != nil {
+			t.Error(err)
+		} else if len(d.Sum()) == 0 {
+			t.Errorf("%s digest should be set", algo)
+		}
+	}
+
+	if _, err := pipe.NewDigest("rot13"); err == nil {
+		t.Errorf("unknown algorithm should fail")
+	}
+
+	v, _ := pipe.NewVerifier(pipe.SHA256, expected)
+	if err := pipe.New(bytes.NewReader(bin)).PushDigest(v).To(ioutil.Discard).Exec(); err != nil {
+		t.Errorf("pipe should not have error %s", err)
+	}
+
+	var mismatch *pipe.DigestMismatchError
+	v, _ = pipe.NewVerifier(pipe.SHA256, expected)
+	if err := pipe.New(bytes.NewReader(bin[1:])).PushDigest(v).To(ioutil.Discard).Exec(); !errors.As(err, &mismatch) {
+		t.Errorf("pipe should fail with a digest mismatch, got %v", err)
+	}
+
+	// the end of a stream is only written once verified.
+	var out bytes.Buffer
+	v, _ = pipe.NewVerifier(pipe.SHA256, expected)
+	if err := v.Filter(bytes.NewReader(bin[1:]), &out); !errors.As(err, &mismatch) {
+		t.Errorf("verifier should fail with a digest mismatch, got %v", err)
+	} else if out.Len() >= len(bin)-1 {
+		t.Errorf("verifier should not write the complete stream")
+	}
+	out.Reset()
+	if err := v.Filter(bytes.NewReader(bin), &out); err != nil {
+		t.Error(err)
+	} else if !bytes.Equal(out.Bytes(), bin) {
+		t.Errorf("verifier should write the complete stream")
+	}
+}
+
+func TestChain(t *testing.T) {
+	var result bytes.Buffer
+	p := pipe.New(bytes.NewReader(bin)).Push(pipe.Chain(zip, pipe.Identity, unzip)).To(&result)
+	if err := p.Exec(); err != nil {
+		t.Fatalf("pipe should not have error %s", err)
+	} else if !bytes.Equal(result.Bytes(), bin) {
+		t.Errorf("result do not match")
+	}
+
+	someErr := errors.New("some error!")
+	var procErr = func(r io.Reader, w io.Writer) error {
+		return someErr
+	}
+	p = pipe.New(bytes.NewReader(bin)).Push(pipe.Chain(zip, procErr)).To(ioutil.Discard)
+	if err := p.Exec(); !errors.Is(err, someErr) {
+		t.Errorf("pipe should return the filter error, got %v", err)
+	}
+}
+
+func TestIfAndMap(t *testing.T) {
+	lower := pipe.Map(bytes.ToLower)
+	enabled := false
+
+	var result bytes.Buffer
+	p := pipe.New(bytes.NewReader(bin)).Push(pipe.If(func() bool { return enabled }, lower)).To(&result)
+	if err := p.Exec(); err != nil {
+		t.Fatalf("pipe should not have error %s", err)
+	} else if !bytes.Equal(result.Bytes(), bin) {
+		t.Errorf("result should not be changed")
+	}
+
+	enabled = true
+	result.Reset()
+	p = pipe.New(bytes.NewReader(bin)).Push(pipe.If(func() bool { return enabled }, lower)).To(&result)
+	if err := p.Exec(); err != nil {
+		t.Fatalf("pipe should not have error %s", err)
+	} else if !bytes.Equal(result.Bytes(), bytes.ToLower(bin)) {
+		t.Errorf("result should be in lower case")
+	}
+}
+
+func TestSwitch(t *testing.T) {
+	jpeg, err := ioutil.ReadFile("tests/test.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sw := pipe.Switch(
+		pipe.Case{Pattern: "image/*"},
+		pipe.Case{Pattern: "*", Filter: zip},
+	)
+
+	var result bytes.Buffer
+	if err := pipe.New(bytes.NewReader(jpeg)).Push(sw).To(&result).Exec(); err != nil {
+		t.Fatalf("pipe should not have error %s", err)
+	} else if !bytes.Equal(result.Bytes(), jpeg) {
+		t.Errorf("image should not be compressed")
+	}
+
+	result.Reset()
+	if err := pipe.New(bytes.NewReader(bin)).Push(sw, unzip).To(&result).Exec(); err != nil {
+		t.Fatalf("pipe should not have error %s", err)
+	} else if !bytes.Equal(result.Bytes(), bin) {
+		t.Errorf("text should be compressed")
+	}
+
+	if !pipe.MatchMediaType("text/*", "text/plain; charset=utf-8") || pipe.MatchMediaType("image/png", "image/jpeg") {
+		t.Errorf("invalid media type matching")
+	}
+}
+
 func TestTee(t *testing.T) {
 	p := pipe.New(bytes.NewReader(bin))
 	p.Push(zip)
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 
+	"github.com/hyperboloide/pipe"
 	"github.com/hyperboloide/pipe/piped/service"
 
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
@@ -30,6 +31,15 @@ var (
 		Short('s').
 		Bool()
 
+	limit = kingpin.Flag("limit", "Maximum throughput of the server in bytes per second.").
+		OverrideDefaultFromEnvar("PIPED_LIMIT").
+		Short('l').
+		Int()
+
+	limitBurst = kingpin.Flag("limit-burst", "Maximum burst in bytes for --limit, defaults to the limit.").
+			OverrideDefaultFromEnvar("PIPED_LIMIT_BURST").
+			Int()
+
 	configPath = kingpin.Arg("config", "Path to the configuration file.").
 			ExistingFile()
 )
@@ -59,7 +69,11 @@ func main() {
 	log.SetFlags(0)
 
 	config := readConfig()
-	r := service.RouterFromConfig(config, *silent)
+	opts := service.RouterOptions{Silent: *silent}
+	if *limit > 0 {
+		opts.Limiter = pipe.NewLimiter(*limit, *limitBurst)
+	}
+	r := service.RouterFromConfigWithOptions(config, opts)
 	log.Printf("piped listenning for http connections on port %d", *port)
 	host := fmt.Sprintf(":%d", *port)
 	if err := http.ListenAndServe(host, r); err != nil {
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/hyperboloide/pipe"
+	"github.com/hyperboloide/pipe/encoders"
+	"github.com/hyperboloide/pipe/encoders/aes"
+	"github.com/hyperboloide/pipe/encoders/aesgcm"
+	"github.com/hyperboloide/pipe/encoders/keyring"
+)
+
+// Auto is a decoder that reads the envelope headers written by the encoders
+// with "envelope": true and decodes the stream accordingly, see
+// encoders.AutoDecode. A stream without envelope is read without changes.
+// Envelopes do not contain secrets, the decoders that need them are set
+// in the "decoders" field:
+//
+//	{"decoder": "auto", "decoders": [{"decoder": "aes", "key": "..."}]}
+type Auto struct {
+	configs map[string]json.RawMessage
+}
+
+// UnmarshalJSON validates and keeps the configuration of the decoders.
+func (a *Auto) UnmarshalJSON(js []byte) error {
+	tmp := struct {
+		Decoders []json.RawMessage `json:"decoders"`
+	}{}
+	if err := json.Unmarshal(js, &tmp); err != nil {
+		return err
+	}
+	a.configs = map[string]json.RawMessage{}
+	for _, d := range tmp.Decoders {
+		t := struct {
+			Type string `json:"decoder"`
+		}{}
+		if err := json.Unmarshal(d, &t); err != nil {
+			return err
+		} else if _, err := DecoderFromJSON(d); err != nil {
+			return err
+		}
+		a.configs[t.Type] = d
+	}
+	return nil
+}
+
+// Start the Auto decoder.
+func (a *Auto) Start() error {
+	return nil
+}
+
+// envelopeParams are the parameters that each encoder describes in its
+// envelope headers. The parameters set to true are needed to decode and are
+// applied to the decoder, the others are only informative. Envelopes are
+// read from the stream and cannot be trusted: an envelope with any other
+// parameter is refused, it must not set a key file or the URL of a KMS.
+var envelopeParams = map[string]map[string]bool{
+	"gzip":       {"level": false},
+	"flate":      {"level": false},
+	"zlib":       {"level": false},
+	"lzw":        {"order": true, "lit_width": true},
+	"openpgp":    {"armor": true},
+	"tar":        {"name": true, "gzip": true},
+	"zip":        {"name": true},
+	"aes":        {"key_id": false},
+	"aesgcm":     {"key_id": false},
+	"passphrase": {},
+	"kms":        {},
+}
+
+// keyringOf returns the keyring of the decoders that have one.
+func keyringOf(ed encoders.EncoderDecoder) *keyring.Keyring {
+	switch v := ed.(type) {
+	case *aes.AES:
+		return &v.Keyring
+	case *aesgcm.AESGCM:
+		return &v.Keyring
+	}
+	return nil
+}
+
+// params returns the parameters of l to apply to its decoder.
+func params(l *encoders.Layer) (map[string]interface{}, error) {
+	allowed, ok := envelopeParams[l.Type]
+	if !ok {
+		return nil, fmt.Errorf("envelope of type '%s' is not supported", l.Type)
+	}
+	res := map[string]interface{}{}
+	for k, v := range l.Params {
+		apply, ok := allowed[k]
+		if !ok {
+			return nil, fmt.Errorf("envelope of type '%s' has an invalid parameter '%s'", l.Type, k)
+		} else if apply {
+			res[k] = v
+		}
+	}
+	return res, nil
+}
+
+// decoder builds the decoder of a layer. Only the parameters of the layer
+// listed in envelopeParams are applied, and the configuration of the decoder
+// in "decoders" overrides them.
+func (a *Auto) decoder(l *encoders.Layer) (pipe.Filter, error) {
+	res := EncoderDecoderFromString(l.Type)
+	describer, ok := res.(encoders.Describer)
+	if !ok {
+		return nil, fmt.Errorf("envelope of type '%s' is not supported", l.Type)
+	}
+	p, err := params(l)
+	if err != nil {
+		return nil, err
+	} else if len(p) > 0 {
+		js, err := json.Marshal(p)
+		if err != nil {
+			return nil, err
+		} else if err := json.Unmarshal(js, res); err != nil {
+			return nil, err
+		}
+	}
+	config := a.configs[l.Type]
+	if config != nil {
+		if err := json.Unmarshal(config, res); err != nil {
+			return nil, err
+		}
+	}
+	if err := res.Start(); err != nil {
+		return nil, err
+	} else if version := describer.Describe().Version; l.Version > version {
+		return nil, fmt.Errorf("envelope of type '%s' version %d is not supported", l.Type, l.Version)
+	}
+	if id, ok := l.Params["key_id"]; ok {
+		keys := keyringOf(res)
+		if s, ok := id.(string); !ok || keys == nil {
+			return nil, fmt.Errorf("envelope of type '%s' has an invalid key ID", l.Type)
+		} else if _, err := keys.Key(s); err != nil {
+			return nil, fmt.Errorf("envelope of type '%s': key '%s': %s", l.Type, s, err)
+		}
+	}
+
+	if l.Parallel {
+		workers := 0
+		if config != nil {
+			if parallel, err := ParallelismFromJSON(config); err != nil {
+				return nil, err
+			} else if parallel != nil {
+				workers = parallel.Workers
+			}
+		}
+		return pipe.ParallelDecode(res.Decode, workers), nil
+	}
+	return res.Decode, nil
+}
+
+// Encode is not supported, use encoders with "envelope": true.
+func (a *Auto) Encode(r io.Reader, w io.Writer) error {
+	return errors.New("auto can only be used as a decoder")
+}
+
+// Decode the stream according to its envelope headers.
+func (a *Auto) Decode(r io.Reader, w io.Writer) error {
+	return encoders.AutoDecode(a.decoder)(r, w)
+}
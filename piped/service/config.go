@@ -5,10 +5,19 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/hyperboloide/pipe"
 	"github.com/hyperboloide/pipe/encoders"
 	"github.com/hyperboloide/pipe/encoders/aes"
+	"github.com/hyperboloide/pipe/encoders/aesgcm"
+	"github.com/hyperboloide/pipe/encoders/archive"
+	"github.com/hyperboloide/pipe/encoders/bzip2"
+	"github.com/hyperboloide/pipe/encoders/flate"
 	"github.com/hyperboloide/pipe/encoders/gzip"
+	"github.com/hyperboloide/pipe/encoders/kms"
+	"github.com/hyperboloide/pipe/encoders/lzw"
 	"github.com/hyperboloide/pipe/encoders/openpgp"
+	"github.com/hyperboloide/pipe/encoders/passphrase"
+	"github.com/hyperboloide/pipe/encoders/zlib"
 	"github.com/hyperboloide/pipe/rw"
 	"github.com/hyperboloide/pipe/rw/file"
 	"github.com/hyperboloide/pipe/rw/gcs"
@@ -23,7 +32,7 @@ var (
 // GetElementType return a string representing the element type.
 func GetElementType(js json.RawMessage) (string, error) {
 	tmp := map[string]interface{}{}
-	types := []string{"tee", "encoder", "decoder", "input", "output"}
+	types := []string{"tee", "encoder", "decoder", "throttle", "switch", "filter", "input", "output"}
 	if err := json.Unmarshal(js, &tmp); err != nil {
 		return "", err
 	}
@@ -35,6 +44,45 @@ func GetElementType(js json.RawMessage) (string, error) {
 	return "", ErrUnknowElementType
 }
 
+// stepName returns a name for an element of type t, for example
+// "encoder gzip".
+func stepName(js json.RawMessage, t string) (string, error) {
+	tmp := map[string]interface{}{}
+	if err := json.Unmarshal(js, &tmp); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %v", t, tmp[t]), nil
+}
+
+// ThrottleFromJSON builds a throttle from json.
+func ThrottleFromJSON(js json.RawMessage) (*Throttle, error) {
+	res := &Throttle{}
+	return res, UnmarshalAndStart(res, js)
+}
+
+// Parallelism configures the parallel execution of an encoder or a decoder,
+// see pipe.Parallel. An encoder and the decoder that reads its output
+// should both be parallel.
+type Parallelism struct {
+	Workers   int `json:"workers"`
+	ChunkSize int `json:"chunk_size"`
+}
+
+// ParallelismFromJSON returns the "parallel" field of an element or nil.
+func ParallelismFromJSON(js json.RawMessage) (*Parallelism, error) {
+	tmp := struct {
+		Parallel *Parallelism `json:"parallel"`
+	}{}
+	if err := json.Unmarshal(js, &tmp); err != nil {
+		return nil, err
+	} else if tmp.Parallel != nil && (tmp.Parallel.Workers < 0 || tmp.Parallel.ChunkSize < 0) {
+		return nil, errors.New("parallel workers and chunk_size cannot be negative")
+	} else if tmp.Parallel != nil && tmp.Parallel.ChunkSize > pipe.MaxChunkSize {
+		return nil, fmt.Errorf("parallel chunk_size cannot exceed %d", pipe.MaxChunkSize)
+	}
+	return tmp.Parallel, nil
+}
+
 // Startable is an object that implements a Start() method. It'is used
 // to start encoders and rws.
 type Startable interface {
@@ -60,9 +108,29 @@ func EncoderFromJSON(js json.RawMessage) (encoders.Encoder, error) {
 		return nil, err
 	} else if res := EncoderDecoderFromString(tmp.Type); res == nil {
 		return nil, fmt.Errorf("encoder of type '%s' is not supported", tmp.Type)
+	} else if err := UnmarshalAndStart(res, js); err != nil {
+		return nil, err
+	} else if !canEncode(res) {
+		return nil, fmt.Errorf("%s can only be used as a decoder", tmp.Type)
 	} else {
-		return res, UnmarshalAndStart(res, js)
+		return res, nil
+	}
+}
+
+// canEncode returns false for the decoders that cannot encode, like bzip2
+// and auto, and for the chains that contain one.
+func canEncode(ed encoders.EncoderDecoder) bool {
+	switch v := ed.(type) {
+	case *bzip2.Bzip2, *Auto:
+		return false
+	case *Chain:
+		for _, e := range v.Encoders {
+			if !canEncode(e) {
+				return false
+			}
+		}
 	}
+	return true
 }
 
 // DecoderFromJSON builds a decoder from json.
@@ -143,10 +211,68 @@ func EncoderDecoderFromString(str string) encoders.EncoderDecoder {
 	switch str {
 	case "gzip":
 		res = &gzip.Gzip{}
+	case "zlib":
+		res = &zlib.Zlib{}
+	case "flate":
+		res = &flate.Flate{}
+	case "lzw":
+		res = &lzw.LZW{}
+	case "bzip2":
+		res = &bzip2.Bzip2{}
+	case "tar":
+		res = &archive.Tar{}
+	case "zip":
+		res = &archive.Zip{}
 	case "aes":
 		res = &aes.AES{}
+	case "aesgcm":
+		res = &aesgcm.AESGCM{}
+	case "kms":
+		res = &kms.KMS{}
+	case "passphrase":
+		res = &passphrase.Passphrase{}
 	case "openpgp":
 		res = &openpgp.OpenPGP{}
+	case "chain":
+		res = &Chain{}
+	case "auto":
+		res = &Auto{}
 	}
 	return res
 }
+
+// Chain is an encoder and decoder made of other encoders listed in
+// the "chain" field in encoding order, see encoders.Composed.
+// When used as a decoder the chain is decoded in reverse order.
+type Chain struct {
+	encoders.Composed
+}
+
+// UnmarshalJSON builds the encoders of the chain.
+func (c *Chain) UnmarshalJSON(js []byte) error {
+	tmp := struct {
+		Chain []json.RawMessage `json:"chain"`
+	}{}
+	if err := json.Unmarshal(js, &tmp); err != nil {
+		return err
+	} else if len(tmp.Chain) == 0 {
+		return errors.New("chain should define at least 1 encoder")
+	}
+	c.Encoders = nil
+	for _, e := range tmp.Chain {
+		t := struct {
+			Type string `json:"encoder"`
+		}{}
+		if err := json.Unmarshal(e, &t); err != nil {
+			return err
+		}
+		res := EncoderDecoderFromString(t.Type)
+		if res == nil {
+			return fmt.Errorf("encoder of type '%s' is not supported", t.Type)
+		} else if err := json.Unmarshal(e, res); err != nil {
+			return err
+		}
+		c.Encoders = append(c.Encoders, res)
+	}
+	return nil
+}
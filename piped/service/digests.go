@@ -0,0 +1,124 @@
+package service
+
+import (
+	"io/ioutil"
+	"strings"
+
+	"github.com/hyperboloide/pipe"
+	"github.com/hyperboloide/pipe/rw"
+)
+
+// DigestID returns the id of the object that stores the digest
+// of the object with id.
+func DigestID(id, algorithm string) string {
+	return id + "." + algorithm
+}
+
+// checkDigestID returns an *rw.InvalidIDError if id is reserved for
+// the digests of one of the algorithms: an object with such an id
+// would overwrite or be read as the digest of another object.
+func checkDigestID(id string, algorithms []string) error {
+	if isDigestID(id, algorithms) {
+		return &rw.InvalidIDError{ID: id, Reason: "reserved for digests"}
+	}
+	return nil
+}
+
+// isDigestID returns true if id is the id of a digest of one
+// of the algorithms.
+func isDigestID(id string, algorithms []string) bool {
+	for _, a := range algorithms {
+		if strings.HasSuffix(id, "."+a) {
+			return true
+		}
+	}
+	return false
+}
+
+// detachedBranch returns true if branch or one of its parents
+// failed and was detached.
+func detachedBranch(branch string, detached pipe.Errors) bool {
+	for _, se := range detached.Stages() {
+		if branch == se.Branch || strings.HasPrefix(branch, se.Branch+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteDigests stores the digests of the outputs next to the object
+// with id. Outputs of detached tees are skipped.
+func WriteDigests(outputs []*Output, id string, detached pipe.Errors) error {
+	for _, out := range outputs {
+		if detachedBranch(out.Branch, detached) {
+			continue
+		}
+		for _, d := range out.Digests {
+			w, err := out.Writer.NewWriter(DigestID(id, d.Algorithm))
+			if err != nil {
+				return err
+			} else if _, err := w.Write([]byte(d.Hex())); err != nil {
+				w.Close()
+				return err
+			} else if err := w.Close(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ReadDigest returns the stored digest of the object with id.
+func ReadDigest(r rw.Reader, id, algorithm string) (string, error) {
+	reader, err := r.NewReader(DigestID(id, algorithm))
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	b, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// NewDigestVerifier returns a pipe.Digest that verifies the object with
+// id against its stored digest while it is read.
+func NewDigestVerifier(r rw.Reader, id, algorithm string) (*pipe.Digest, error) {
+	expected, err := ReadDigest(r, id, algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return pipe.NewVerifier(algorithm, expected)
+}
+
+// digestDeleter deletes the stored digests along with the objects.
+type digestDeleter struct {
+	rw.Deleter
+	algorithms []string
+}
+
+// Delete the object and its digests, missing digests are ignored.
+func (d *digestDeleter) Delete(id string) error {
+	if err := checkDigestID(id, d.algorithms); err != nil {
+		return err
+	} else if err := d.Deleter.Delete(id); err != nil {
+		return err
+	}
+	for _, a := range d.algorithms {
+		d.Deleter.Delete(DigestID(id, a))
+	}
+	return nil
+}
+
+// digestsByAlgorithm returns the digests as a map of hex strings.
+func digestsByAlgorithm(digests []*pipe.Digest) map[string]string {
+	if len(digests) == 0 {
+		return nil
+	}
+	res := map[string]string{}
+	for _, d := range digests {
+		res[d.Algorithm] = d.Hex()
+	}
+	return res
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+
+	"github.com/hyperboloide/pipe/filters/records"
+	"github.com/hyperboloide/pipe/filters/text"
+)
+
+// FilterStep is a step that applies a pipe.Filter of the filters packages
+// in writers and readers. The filter is defined by the "filter" field:
+//
+//	{"filter": "replace", "pattern": "password=\\S+", "replacement": "password=***"}
+//	{"filter": "redact", "emails": true, "credit_cards": true, "mask": "[REDACTED]"}
+//	{"filter": "grep", "pattern": "^ERROR", "invert": false}
+//	{"filter": "newlines", "ending": "\n"}
+//	{"filter": "latin1"}
+//	{"filter": "records", "from": "csv", "to": "jsonl", "fields": ["id"], "where": [{"field": "age", "op": "gt", "value": 30}]}
+//
+// Line based filters accept a "max_line_size", see text.Lines.
+// The "records" filter converts records between the formats of the records
+// package, "to" defaults to "from".
+type FilterStep struct {
+	filterStep
+}
+
+// textFilterConfig is the configuration of the filters of the text package.
+type textFilterConfig struct {
+	Type        string   `json:"filter"`
+	Pattern     string   `json:"pattern"`
+	Replacement string   `json:"replacement"`
+	Invert      bool     `json:"invert"`
+	Emails      bool     `json:"emails"`
+	CreditCards bool     `json:"credit_cards"`
+	Patterns    []string `json:"patterns"`
+	Mask        string   `json:"mask"`
+	Ending      string   `json:"ending"`
+	MaxLineSize int      `json:"max_line_size"`
+}
+
+// recordsFilterConfig is the configuration of the records filter.
+type recordsFilterConfig struct {
+	From   records.Format      `json:"from"`
+	To     records.Format      `json:"to"`
+	Fields []string            `json:"fields"`
+	Where  []records.Predicate `json:"where"`
+}
+
+// FilterFromJSON builds a FilterStep from json.
+func FilterFromJSON(js json.RawMessage) (*FilterStep, error) {
+	cfg := textFilterConfig{}
+	if err := json.Unmarshal(js, &cfg); err != nil {
+		return nil, err
+	} else if cfg.MaxLineSize < 0 {
+		return nil, errors.New("filter max_line_size cannot be negative")
+	}
+
+	var re *regexp.Regexp
+	if cfg.Pattern != "" {
+		var err error
+		if re, err = regexp.Compile(cfg.Pattern); err != nil {
+			return nil, err
+		}
+	}
+
+	switch cfg.Type {
+	case "replace", "grep":
+		if re == nil {
+			return nil, fmt.Errorf("filter '%s' should define a pattern", cfg.Type)
+		} else if cfg.Type == "replace" {
+			return &FilterStep{filterStep{text.Replace(re, cfg.Replacement, cfg.MaxLineSize)}}, nil
+		}
+		return &FilterStep{filterStep{text.Grep(re, cfg.Invert, cfg.MaxLineSize)}}, nil
+	case "redact":
+		var redactions []text.Redaction
+		if cfg.Emails {
+			redactions = append(redactions, text.Emails(cfg.Mask))
+		}
+		if cfg.CreditCards {
+			redactions = append(redactions, text.CreditCards(cfg.Mask))
+		}
+		for _, p := range cfg.Patterns {
+			pre, err := regexp.Compile(p)
+			if err != nil {
+				return nil, err
+			}
+			redactions = append(redactions, text.Redaction{Pattern: pre, Mask: cfg.Mask})
+		}
+		if len(redactions) == 0 {
+			return nil, errors.New("filter 'redact' should define what to redact")
+		}
+		return &FilterStep{filterStep{text.Redact(redactions, cfg.MaxLineSize)}}, nil
+	case "newlines":
+		if cfg.Ending != "\n" && cfg.Ending != "\r\n" {
+			return nil, errors.New("filter 'newlines' ending should be \"\\n\" or \"\\r\\n\"")
+		}
+		return &FilterStep{filterStep{text.NormalizeNewlines(cfg.Ending)}}, nil
+	case "latin1":
+		return &FilterStep{filterStep{text.Latin1ToUTF8}}, nil
+	case "records":
+		return recordsFilterFromJSON(js)
+	}
+	return nil, fmt.Errorf("filter of type '%s' is not supported", cfg.Type)
+}
+
+// recordsFilterFromJSON builds a records filter from json.
+func recordsFilterFromJSON(js json.RawMessage) (*FilterStep, error) {
+	cfg := recordsFilterConfig{}
+	if err := json.Unmarshal(js, &cfg); err != nil {
+		return nil, err
+	} else if cfg.To == "" {
+		cfg.To = cfg.From
+	}
+	for _, format := range []records.Format{cfg.From, cfg.To} {
+		if _, err := records.NewWriter(format, ioutil.Discard); err != nil {
+			return nil, err
+		}
+	}
+
+	var fns []records.Func
+	for _, p := range cfg.Where {
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	if len(cfg.Where) > 0 {
+		fns = append(fns, records.Where(cfg.Where...))
+	}
+	if len(cfg.Fields) > 0 {
+		fns = append(fns, records.Project(cfg.Fields...))
+	}
+	return &FilterStep{filterStep{records.Convert(cfg.From, cfg.To, fns...)}}, nil
+}
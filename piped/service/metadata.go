@@ -0,0 +1,86 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/hyperboloide/pipe/rw"
+)
+
+// MetadataHeaderPrefix is the prefix of the headers of the user metadata
+// stored with the objects.
+const MetadataHeaderPrefix = "X-Meta-"
+
+// statStatus returns the HTTP status for an error of rw.Stater.Stat.
+func statStatus(err error) int {
+	if errors.Is(err, rw.ErrNotExist) {
+		return http.StatusNotFound
+	}
+	return idErrorStatus(err, http.StatusBadGateway)
+}
+
+// setInfoHeaders sets the headers of a response from the information of
+// an object. Content-Length, Content-Type and ETag are only set if raw is
+// true, when the object is sent without transformation.
+func setInfoHeaders(h http.Header, info *rw.ObjectInfo, raw bool) {
+	if !info.ModTime.IsZero() {
+		h.Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	}
+	for k, v := range info.Metadata {
+		h.Set(MetadataHeaderPrefix+k, v)
+	}
+	if !raw {
+		return
+	}
+	h.Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	if info.ETag != "" {
+		h.Set("ETag", info.ETag)
+	}
+	if info.ContentType != "" {
+		h.Set("Content-Type", info.ContentType)
+	}
+}
+
+// writeOptions returns the rw.WriteOptions of an upload from the
+// X-Meta-* headers of r, or nil if there is no option.
+func writeOptions(r *http.Request, contentType string) *rw.WriteOptions {
+	opts := &rw.WriteOptions{ContentType: contentType}
+	for k := range r.Header {
+		if strings.HasPrefix(k, MetadataHeaderPrefix) {
+			if opts.Metadata == nil {
+				opts.Metadata = map[string]string{}
+			}
+			opts.Metadata[strings.ToLower(k[len(MetadataHeaderPrefix):])] = r.Header.Get(k)
+		}
+	}
+	if opts.ContentType == "" && opts.Metadata == nil {
+		return nil
+	}
+	return opts
+}
+
+// withoutContentType returns opts without the content type, the content
+// type does not apply anymore once the data is transformed.
+func withoutContentType(opts *rw.WriteOptions) *rw.WriteOptions {
+	if opts == nil || opts.ContentType == "" {
+		return opts
+	}
+	res := *opts
+	res.ContentType = ""
+	if res.Metadata == nil {
+		return nil
+	}
+	return &res
+}
+
+// newWriter returns a writer of w for id that stores opts if w is
+// a rw.MetadataWriter.
+func newWriter(w rw.Writer, id string, opts *rw.WriteOptions) (io.WriteCloser, error) {
+	if mw, ok := w.(rw.MetadataWriter); ok && opts != nil {
+		return mw.NewWriterWithOptions(id, opts)
+	}
+	return w.NewWriter(id)
+}
@@ -1,20 +1,186 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 
 	"github.com/hyperboloide/pipe"
 	"github.com/hyperboloide/pipe/encoders"
 	"github.com/hyperboloide/pipe/rw"
 )
 
+// NamedEncoder is an encoder with the type it has in the configuration,
+// the name identifies the encoder when the pipe fails.
+type NamedEncoder struct {
+	encoders.Encoder
+	Name     string
+	Parallel *Parallelism
+	// Write an envelope header describing the encoder before its output,
+	// see encoders.Envelope.
+	Envelope bool
+	// The input of the encoder starts with an envelope, set by
+	// nestEnvelopes.
+	Nested bool
+}
+
+// NamedEncoderFromJSON builds a NamedEncoder from an "encoder" element.
+func NamedEncoderFromJSON(js json.RawMessage) (*NamedEncoder, error) {
+	tmp := struct {
+		Envelope bool `json:"envelope"`
+	}{}
+	step, err := EncoderFromJSON(js)
+	if err != nil {
+		return nil, err
+	} else if err := json.Unmarshal(js, &tmp); err != nil {
+		return nil, err
+	}
+	name, err := stepName(js, "encoder")
+	if err != nil {
+		return nil, err
+	}
+	parallel, err := ParallelismFromJSON(js)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := step.(encoders.Describer); tmp.Envelope && !ok {
+		return nil, fmt.Errorf("%s cannot be described in an envelope", name)
+	}
+	return &NamedEncoder{Encoder: step, Name: name, Parallel: parallel, Envelope: tmp.Envelope}, nil
+}
+
+// contextFilter is implemented by the steps that stop with the context
+// of the pipe, like Throttle.
+type contextFilter interface {
+	FilterContext(ctx context.Context) pipe.Filter
+}
+
+// filterStep is a step that applies the same filter to encode and
+// decode. It implements encoders.EncoderDecoder so that it can be used in
+// both writers and readers.
+type filterStep struct {
+	filter pipe.Filter
+}
+
+// Start the step.
+func (f *filterStep) Start() error {
+	return nil
+}
+
+// Encode applies the filter.
+func (f *filterStep) Encode(r io.Reader, w io.Writer) error {
+	return f.filter(r, w)
+}
+
+// Decode applies the filter.
+func (f *filterStep) Decode(r io.Reader, w io.Writer) error {
+	return f.filter(r, w)
+}
+
+// Filter returns the function to push in the pipe.
+func (e *NamedEncoder) Filter() pipe.Filter {
+	return e.FilterContext(context.Background())
+}
+
+// FilterContext is like Filter, the steps that wait stop when ctx is done.
+func (e *NamedEncoder) FilterContext(ctx context.Context) pipe.Filter {
+	f := e.Encode
+	if cf, ok := e.Encoder.(contextFilter); ok {
+		f = cf.FilterContext(ctx)
+	}
+	if e.Parallel != nil {
+		f = pipe.Parallel(f, e.Parallel.Workers, e.Parallel.ChunkSize)
+	}
+	if e.Envelope {
+		l := e.Encoder.(encoders.Describer).Describe()
+		l.Parallel = e.Parallel != nil
+		l.Nested = e.Nested
+		return encoders.Envelope(l, f)
+	}
+	return f
+}
+
+// NamedDecoder is a decoder with the type it has in the configuration,
+// the name identifies the decoder when the pipe fails.
+type NamedDecoder struct {
+	encoders.Decoder
+	Name     string
+	Parallel *Parallelism
+}
+
+// NamedDecoderFromJSON builds a NamedDecoder from a "decoder" element.
+func NamedDecoderFromJSON(js json.RawMessage) (*NamedDecoder, error) {
+	step, err := DecoderFromJSON(js)
+	if err != nil {
+		return nil, err
+	}
+	name, err := stepName(js, "decoder")
+	if err != nil {
+		return nil, err
+	}
+	parallel, err := ParallelismFromJSON(js)
+	if err != nil {
+		return nil, err
+	}
+	return &NamedDecoder{step, name, parallel}, nil
+}
+
+// Filter returns the function to push in the pipe.
+func (d *NamedDecoder) Filter() pipe.Filter {
+	return d.FilterContext(context.Background())
+}
+
+// FilterContext is like Filter, the steps that wait stop when ctx is done.
+func (d *NamedDecoder) FilterContext(ctx context.Context) pipe.Filter {
+	f := d.Decode
+	if cf, ok := d.Decoder.(contextFilter); ok {
+		f = cf.FilterContext(ctx)
+	}
+	if d.Parallel != nil {
+		return pipe.ParallelDecode(f, d.Parallel.Workers)
+	}
+	return f
+}
+
 // WriteOperations represents the various steps and the output necessary
 // to write data.
 type WriteOperations struct {
 	Steps  []interface{}
 	Output rw.Writer
+	// Policy of the operations when used as a tee.
+	Policy pipe.TeePolicy
+	// Digest algorithms computed before the output.
+	Digests []string
+	// Limits the throughput of all the writes when set, it is only
+	// used by the operations of the handler, not by the tees.
+	Limiter *pipe.Limiter
+}
+
+// Output is an output of a pipe with the digests of the data written.
+type Output struct {
+	Branch  string
+	Writer  rw.Writer
+	Digests []*pipe.Digest
+}
+
+// SetDigests sets the digest algorithms of the WriteOperations and its tees.
+func (wo *WriteOperations) SetDigests(algorithms []string) error {
+	for _, a := range algorithms {
+		if _, err := pipe.NewDigest(a); err != nil {
+			return err
+		}
+	}
+	wo.Digests = algorithms
+	for _, s := range wo.Steps {
+		if tee, ok := s.(*WriteOperations); ok {
+			if err := tee.SetDigests(algorithms); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 // AddStep add a step to a WriteOperations from a json.RawMessage.
@@ -31,11 +197,39 @@ func (wo *WriteOperations) AddStep(js json.RawMessage) error {
 		}
 		wo.Steps = append(wo.Steps, ts)
 	case "encoder":
-		step, err := EncoderFromJSON(js)
+		step, err := NamedEncoderFromJSON(js)
 		if err != nil {
 			return err
 		}
 		wo.Steps = append(wo.Steps, step)
+	case "decoder":
+		step, err := NamedDecoderFromJSON(js)
+		if err != nil {
+			return err
+		}
+		wo.Steps = append(wo.Steps, step)
+	case "throttle":
+		step, err := ThrottleFromJSON(js)
+		if err != nil {
+			return err
+		}
+		wo.Steps = append(wo.Steps, &NamedEncoder{Encoder: step, Name: t})
+	case "switch":
+		step, err := SwitchFromJSON(js, "encoder")
+		if err != nil {
+			return err
+		}
+		wo.Steps = append(wo.Steps, &NamedEncoder{Encoder: step, Name: t})
+	case "filter":
+		step, err := FilterFromJSON(js)
+		if err != nil {
+			return err
+		}
+		name, err := stepName(js, t)
+		if err != nil {
+			return err
+		}
+		wo.Steps = append(wo.Steps, &NamedEncoder{Encoder: step, Name: name})
 	case "output":
 		return errors.New("element of type 'output' should appear only once as the last element of a writer")
 	default:
@@ -44,16 +238,38 @@ func (wo *WriteOperations) AddStep(js json.RawMessage) error {
 	return nil
 }
 
+// TeePolicyFromString returns a pipe.TeePolicy from it's name.
+// The quorum is used only by the "quorum" policy.
+func TeePolicyFromString(str string, quorum int) (pipe.TeePolicy, error) {
+	switch str {
+	case "", "required":
+		return pipe.Required, nil
+	case "best_effort":
+		return pipe.BestEffort, nil
+	case "quorum":
+		if quorum < 1 {
+			return pipe.Required, errors.New("a tee with a quorum policy should define a quorum of at least 1")
+		}
+		return pipe.Quorum(quorum), nil
+	}
+	return pipe.Required, fmt.Errorf("tee policy '%s' is not supported", str)
+}
+
 // NewTeeFromJSON build a WriteOperations for a tee from json.
 func NewTeeFromJSON(js json.RawMessage) (*WriteOperations, error) {
 	tmp := struct {
-		Tee json.RawMessage `json:"tee"`
+		Tee    json.RawMessage `json:"tee"`
+		Policy string          `json:"policy"`
+		Quorum int             `json:"quorum"`
 	}{}
 	if err := json.Unmarshal(js, &tmp); err != nil {
 		return nil, err
+	} else if policy, err := TeePolicyFromString(tmp.Policy, tmp.Quorum); err != nil {
+		return nil, err
 	} else if step, err := NewWriteOperationsFromJSON(tmp.Tee); err != nil {
 		return nil, err
 	} else {
+		step.Policy = policy
 		return step, nil
 	}
 }
@@ -82,36 +298,115 @@ func NewWriteOperationsFromJSON(js json.RawMessage) (*WriteOperations, error) {
 		return nil, err
 	}
 	res.Output = w
+	nestEnvelopes(res.Steps, false)
 	return res, nil
 }
 
+// nestEnvelopes marks the encoders with an envelope whose input starts with
+// the envelope of a previous encoder, enveloped is true if the input of the
+// steps does. Throttles do not change the stream.
+func nestEnvelopes(steps []interface{}, enveloped bool) {
+	for _, s := range steps {
+		switch s := s.(type) {
+		case *NamedEncoder:
+			if _, ok := s.Encoder.(*Throttle); !ok {
+				s.Nested = s.Envelope && enveloped
+				enveloped = s.Envelope
+			}
+		case *NamedDecoder:
+			if _, ok := s.Decoder.(*Throttle); !ok {
+				enveloped = false
+			}
+		case *WriteOperations:
+			nestEnvelopes(s.Steps, enveloped)
+		}
+	}
+}
+
 // SetPipe set the various encoders, tees and the writer.
 func (wo *WriteOperations) SetPipe(p *pipe.Pipe, id string) error {
+	_, err := wo.SetPipeOutputs(p, id)
+	return err
+}
+
+// SetPipeOutputs is like SetPipe and also returns the outputs of the pipe
+// and its tees, their digests are set when the pipe completes.
+func (wo *WriteOperations) SetPipeOutputs(p *pipe.Pipe, id string) ([]*Output, error) {
+	return wo.SetPipeOutputsWithOptions(p, id, nil)
+}
+
+// SetPipeOutputsWithOptions is like SetPipeOutputs and stores opts with
+// the objects of the outputs that are rw.MetadataWriter. The content type
+// is only stored by the outputs that receive the data untransformed.
+func (wo *WriteOperations) SetPipeOutputsWithOptions(p *pipe.Pipe, id string, opts *rw.WriteOptions) ([]*Output, error) {
+	var outputs []*Output
 	for _, s := range wo.Steps {
 		switch s.(type) {
+		case *NamedEncoder:
+			p.PushNamed(s.(*NamedEncoder).Name, s.(*NamedEncoder).FilterContext(p.Context()))
+			opts = withoutContentType(opts)
+		case *NamedDecoder:
+			p.PushNamed(s.(*NamedDecoder).Name, s.(*NamedDecoder).FilterContext(p.Context()))
+			opts = withoutContentType(opts)
 		case encoders.Encoder:
 			p.Push(s.(encoders.Encoder).Encode)
+			opts = withoutContentType(opts)
 		case *WriteOperations:
-			tp := p.Tee()
 			teeWo := s.(*WriteOperations)
-			if err := teeWo.SetPipe(tp, id); err != nil {
-				return err
+			tp := p.TeeWithPolicy(teeWo.Policy)
+			teeOutputs, err := teeWo.SetPipeOutputsWithOptions(tp, id, opts)
+			if err != nil {
+				return nil, err
 			}
+			outputs = append(outputs, teeOutputs...)
 		}
 	}
-	w, err := wo.Output.NewWriter(id)
-	if err != nil {
-		return err
+
+	out := &Output{Branch: p.Branch(), Writer: wo.Output}
+	for _, a := range wo.Digests {
+		d, err := pipe.NewDigest(a)
+		if err != nil {
+			return nil, err
+		}
+		p.PushDigest(d)
+		out.Digests = append(out.Digests, d)
+	}
+
+	w, err := newWriter(wo.Output, id, opts)
+	if err != nil && wo.Policy == pipe.Required {
+		return nil, err
+	} else if err != nil {
+		// let the pipe detach the tee.
+		w = &failedWriter{err}
 	}
 	p.ToCloser(w)
-	return nil
+	return append([]*Output{out}, outputs...), nil
+}
+
+// failedWriter is an io.WriteCloser that always fails with err.
+type failedWriter struct {
+	err error
+}
+
+func (f *failedWriter) Write(p []byte) (int, error) {
+	return 0, f.err
+}
+
+func (f *failedWriter) Close() error {
+	return f.err
 }
 
 // ReadOperations represents the various steps and the input necessary
 // to retrieve data.
 type ReadOperations struct {
-	Steps []encoders.Decoder
+	Steps []interface{}
 	Input rw.Reader
+	// Digest algorithm verified while reading, see NewDigestVerifier.
+	Verify string
+	// Read the objects without a stored digest unverified.
+	AllowUnverified bool
+	// Limits the throughput of all the reads when set.
+	Limiter *pipe.Limiter
 }
 
 // AddStep add a step to a ReadOperations from a json.RawMessage.
@@ -122,15 +417,43 @@ func (ro *ReadOperations) AddStep(js json.RawMessage) error {
 	}
 	switch t {
 	case "decoder":
-		step, err := DecoderFromJSON(js)
+		step, err := NamedDecoderFromJSON(js)
+		if err != nil {
+			return err
+		}
+		ro.Steps = append(ro.Steps, step)
+	case "encoder":
+		step, err := NamedEncoderFromJSON(js)
 		if err != nil {
 			return err
 		}
 		ro.Steps = append(ro.Steps, step)
+	case "throttle":
+		step, err := ThrottleFromJSON(js)
+		if err != nil {
+			return err
+		}
+		ro.Steps = append(ro.Steps, &NamedDecoder{Decoder: step, Name: t})
+	case "switch":
+		step, err := SwitchFromJSON(js, "decoder")
+		if err != nil {
+			return err
+		}
+		ro.Steps = append(ro.Steps, &NamedDecoder{Decoder: step, Name: t})
+	case "filter":
+		step, err := FilterFromJSON(js)
+		if err != nil {
+			return err
+		}
+		name, err := stepName(js, t)
+		if err != nil {
+			return err
+		}
+		ro.Steps = append(ro.Steps, &NamedDecoder{Decoder: step, Name: name})
 	case "input":
-		return fmt.Errorf("element of type '%s' is not available inside a reader", t)
-	default:
 		return errors.New("element of type 'input' should appear only once as the first element of a reader")
+	default:
+		return fmt.Errorf("element of type '%s' is not available inside a reader", t)
 	}
 	return nil
 }
@@ -160,13 +483,34 @@ func NewReadOperationsFromJSON(js json.RawMessage) (*ReadOperations, error) {
 			return nil, err
 		}
 	}
+	nestEnvelopes(res.Steps, false)
 	return res, nil
 }
 
-// SetPipe adds the decoders to the pipe.
+// Raw returns true if the steps do not transform the data, when there
+// are no steps or only throttles. The stored object is then sent as is.
+func (ro *ReadOperations) Raw() bool {
+	for _, s := range ro.Steps {
+		if nd, ok := s.(*NamedDecoder); !ok {
+			return false
+		} else if _, ok := nd.Decoder.(*Throttle); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// SetPipe adds the decoders and encoders to the pipe.
 func (ro *ReadOperations) SetPipe(p *pipe.Pipe) error {
 	for _, s := range ro.Steps {
-		p.Push(s.Decode)
+		switch s.(type) {
+		case *NamedDecoder:
+			p.PushNamed(s.(*NamedDecoder).Name, s.(*NamedDecoder).FilterContext(p.Context()))
+		case *NamedEncoder:
+			p.PushNamed(s.(*NamedEncoder).Name, s.(*NamedEncoder).FilterContext(p.Context()))
+		case encoders.Decoder:
+			p.Push(s.(encoders.Decoder).Decode)
+		}
 	}
 	return nil
 }
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperboloide/pipe/rw"
+)
+
+// errUnsatisfiableRange is returned by requestRange when the range is
+// outside of the object.
+var errUnsatisfiableRange = errors.New("unsatisfiable range")
+
+// requestRange returns the range of the object requested with the Range
+// and If-Range headers of r. ok is false if the whole object should be
+// sent: without a Range header, with an invalid or multiple ranges, or
+// if the If-Range validator does not match the object.
+func requestRange(r *http.Request, info *rw.ObjectInfo) (offset, length int64, ok bool, err error) {
+	header := r.Header.Get("Range")
+	if !strings.HasPrefix(header, "bytes=") || !ifRangeMatch(r.Header.Get("If-Range"), info) {
+		return 0, 0, false, nil
+	}
+	spec := strings.TrimSpace(header[len("bytes="):])
+	i := strings.Index(spec, "-")
+	if i < 0 || strings.Contains(spec, ",") {
+		return 0, 0, false, nil
+	}
+	start, end := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+
+	if start == "" {
+		// suffix range, the last bytes of the object.
+		n, err := strconv.ParseInt(end, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, false, nil
+		} else if n == 0 || info.Size == 0 {
+			return 0, 0, false, errUnsatisfiableRange
+		} else if n > info.Size {
+			n = info.Size
+		}
+		return info.Size - n, n, true, nil
+	}
+
+	if offset, err = strconv.ParseInt(start, 10, 64); err != nil || offset < 0 {
+		return 0, 0, false, nil
+	} else if offset >= info.Size {
+		return 0, 0, false, errUnsatisfiableRange
+	}
+	last := info.Size - 1
+	if end != "" {
+		if last, err = strconv.ParseInt(end, 10, 64); err != nil || last < offset {
+			return 0, 0, false, nil
+		} else if last >= info.Size {
+			last = info.Size - 1
+		}
+	}
+	return offset, last - offset + 1, true, nil
+}
+
+// ifRangeMatch returns true if the If-Range validator is empty or matches
+// the strong ETag or the modification time of the object.
+func ifRangeMatch(validator string, info *rw.ObjectInfo) bool {
+	if validator == "" {
+		return true
+	} else if strings.HasPrefix(validator, `"`) {
+		return validator == info.ETag
+	} else if t, err := http.ParseTime(validator); err == nil {
+		return !info.ModTime.IsZero() && info.ModTime.Truncate(time.Second).Equal(t)
+	}
+	return false
+}
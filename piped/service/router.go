@@ -6,12 +6,27 @@ import (
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
+	"github.com/hyperboloide/pipe"
 )
 
+// RouterOptions are the options of RouterFromConfigWithOptions.
+type RouterOptions struct {
+	// Do not log the requests and the services.
+	Silent bool
+	// Limits the throughput of all the reads and writes of the router
+	// when set.
+	Limiter *pipe.Limiter
+}
+
 // RouterFromConfig setup a chi.Mux router from a json configuration.
 func RouterFromConfig(config json.RawMessage, silent bool) *chi.Mux {
+	return RouterFromConfigWithOptions(config, RouterOptions{Silent: silent})
+}
+
+// RouterFromConfigWithOptions is like RouterFromConfig with opts.
+func RouterFromConfigWithOptions(config json.RawMessage, opts RouterOptions) *chi.Mux {
 	r := chi.NewRouter()
-	if !silent {
+	if !opts.Silent {
 		r.Use(middleware.Logger)
 	}
 
@@ -28,11 +43,11 @@ func RouterFromConfig(config json.RawMessage, silent bool) *chi.Mux {
 			srv.WriterPipe == nil {
 			log.Fatalf("service '%s' should define at least a writer, reader or deleter.", srv.URL)
 		}
-		if !silent {
+		if !opts.Silent {
 			log.Printf("registering service with url '%s'", srv.URL)
 		}
 
-		SetHandler(r, srv)
+		SetHandlerWithLimiter(r, srv, opts.Limiter)
 	}
 	return r
 }
@@ -1,10 +1,16 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"sync"
 
 	"github.com/go-chi/chi"
 
@@ -20,6 +26,17 @@ type Definition struct {
 	WriterPipe json.RawMessage `json:"writer,omitempty"`
 	ReaderPipe json.RawMessage `json:"reader,omitempty"`
 	Deleter    json.RawMessage `json:"deleter,omitempty"`
+	// Digest algorithms computed on writes and stored with each output.
+	Digests []string `json:"digests,omitempty"`
+	// Digest algorithm verified while reading, see SetReadHandler.
+	Verify string `json:"verify,omitempty"`
+	// Read the objects without a stored digest unverified, like the
+	// objects written before "digests" was set. They fail with a 409
+	// otherwise.
+	AllowUnverified bool `json:"allow_unverified,omitempty"`
+	// List the ids of the reader input on GET /{url}/, the input should
+	// be a rw.Lister.
+	List bool `json:"list,omitempty"`
 }
 
 // Error display the service url and the encountred error.
@@ -27,22 +44,81 @@ func (d *Definition) Error(err error) {
 	log.Fatalf("service '%s' encountred an error: %s", d.URL, err)
 }
 
+// ErrorStatus returns the HTTP status for the error of a pipe.
+// Failures of the reader are reported with inputStatus and failures of
+// a writer (including tees) with outputStatus, any other failure is a 500.
+// Invalid ids are reported with a 400.
+func ErrorStatus(err error, inputStatus, outputStatus int) int {
+	var se *pipe.StageError
+	var ie *rw.InvalidIDError
+	if errors.As(err, &ie) {
+		return http.StatusBadRequest
+	} else if errors.Is(err, context.Canceled) {
+		return http.StatusServiceUnavailable
+	} else if !errors.As(err, &se) {
+		return http.StatusInternalServerError
+	}
+	switch {
+	case se.Name == pipe.ReaderStage && se.Branch == "":
+		return inputStatus
+	case se.Name == pipe.WriterStage:
+		return outputStatus
+	}
+	return http.StatusInternalServerError
+}
+
+// idErrorStatus returns 400 if err is caused by an invalid id and
+// status otherwise.
+func idErrorStatus(err error, status int) int {
+	var ie *rw.InvalidIDError
+	if errors.As(err, &ie) {
+		return http.StatusBadRequest
+	}
+	return status
+}
+
+// logPipeError logs the error of a pipe with the request.
+func logPipeError(r *http.Request, err error) {
+	log.Printf("%s %s failed: %s", r.Method, r.URL.Path, err)
+}
+
 // SetHandler set the right handler in chi for the provoded ServiceDefinition.
 func SetHandler(r *chi.Mux, d Definition) {
+	SetHandlerWithLimiter(r, d, nil)
+}
+
+// SetHandlerWithLimiter is like SetHandler, all the reads and writes of
+// the handler share limiter when it is not nil.
+func SetHandlerWithLimiter(r *chi.Mux, d Definition, limiter *pipe.Limiter) {
 	r.Route("/"+d.URL, func(r chi.Router) {
 
 		if d.ReaderPipe != nil {
 			if ops, err := NewReadOperationsFromJSON(d.ReaderPipe); err != nil {
 				d.Error(err)
+			} else if _, err := pipe.NewDigest(d.Verify); d.Verify != "" && err != nil {
+				d.Error(err)
 			} else {
+				ops.Verify = d.Verify
+				ops.AllowUnverified = d.AllowUnverified
+				ops.Limiter = limiter
 				SetReadHandler(r, ops)
+				if lister, ok := ops.Input.(rw.Lister); d.List && !ok {
+					d.Error(errors.New("the reader input cannot be listed"))
+				} else if d.List {
+					SetListHandler(r, lister, d.Digests)
+				}
 			}
+		} else if d.List {
+			d.Error(errors.New("listing requires a reader"))
 		}
 
 		if d.WriterPipe != nil {
 			if ops, err := NewWriteOperationsFromJSON(d.WriterPipe); err != nil {
 				d.Error(err)
+			} else if err := ops.SetDigests(d.Digests); err != nil {
+				d.Error(err)
 			} else {
+				ops.Limiter = limiter
 				SetWriteHandler(r, ops)
 			}
 		}
@@ -50,6 +126,8 @@ func SetHandler(r *chi.Mux, d Definition) {
 		if d.Deleter != nil {
 			if del, err := DeleterFromJSON(d.Deleter); err != nil {
 				d.Error(err)
+			} else if len(d.Digests) > 0 {
+				SetDeleteHandler(r, &digestDeleter{del, d.Digests})
 			} else {
 				SetDeleteHandler(r, del)
 			}
@@ -59,34 +137,234 @@ func SetHandler(r *chi.Mux, d Definition) {
 
 }
 
-// SetReadHandler sets a chi handler for a Reader.
+// SetReadHandler sets a chi handler for a Reader. If the input is
+// a rw.Stater and a rw.RangeReader and the steps do not transform the
+// data, parts of the objects are sent with the Range and If-Range headers.
+//
+// With ops.Verify the objects are verified against their stored digest
+// while they are sent: the digest is only known at the end of the object.
+// An object that does not match fails with a 500 if it is smaller than
+// what the verifier holds back (see pipe.NewVerifier), otherwise the
+// response has started and is cut off before its end. Objects without
+// a stored digest fail with a 409 unless ops.AllowUnverified is set.
 func SetReadHandler(r chi.Router, ops *ReadOperations) {
 	handler := func(w http.ResponseWriter, r *http.Request) {
 		id := chi.URLParam(r, "id")
-		if reader, err := ops.Input.NewReader(id); err != nil {
-			http.Error(w, http.StatusText(404), 404)
+		if ops.Verify != "" {
+			if err := checkDigestID(id, []string{ops.Verify}); err != nil {
+				http.Error(w, http.StatusText(400), 400)
+				return
+			}
+		}
+		var info *rw.ObjectInfo
+		if stater, ok := ops.Input.(rw.Stater); ok {
+			var err error
+			if info, err = stater.Stat(id); err != nil {
+				status := statStatus(err)
+				http.Error(w, http.StatusText(status), status)
+				return
+			}
+			setInfoHeaders(w.Header(), info, ops.Raw())
+		}
+
+		var reader io.ReadCloser
+		var err error
+		res := &responseWriter{w: w, status: http.StatusOK}
+		if rr, ok := rangeInput(ops, info); ok {
+			w.Header().Set("Accept-Ranges", "bytes")
+			if offset, length, ok, rerr := requestRange(r, info); rerr != nil {
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size))
+				http.Error(w, http.StatusText(416), 416)
+				return
+			} else if ok {
+				if reader, err = rr.NewRangeReader(id, offset, length); err == nil {
+					w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, info.Size))
+					w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+					res.status = http.StatusPartialContent
+				}
+			}
+		}
+		if reader == nil && err == nil {
+			reader, err = ops.Input.NewReader(id)
+		}
+
+		if err != nil {
+			status := idErrorStatus(err, 404)
+			http.Error(w, http.StatusText(status), status)
 		} else {
 			defer reader.Close()
-			p := pipe.New(reader)
+			// the digest is read once the object is known to exist.
+			var verifier *pipe.Digest
+			if ops.Verify != "" {
+				if verifier, err = NewDigestVerifier(ops.Input, id, ops.Verify); errors.Is(err, rw.ErrNotExist) && ops.AllowUnverified {
+					verifier = nil
+				} else if err != nil {
+					logPipeError(r, err)
+					status := 500
+					if errors.Is(err, rw.ErrNotExist) {
+						status = http.StatusConflict
+					}
+					http.Error(w, http.StatusText(status), status)
+					return
+				}
+			}
+			p := pipe.NewWithContext(r.Context(), reader)
+			if verifier != nil {
+				p.PushDigest(verifier)
+			}
 			if err := ops.SetPipe(p); err != nil {
 				http.Error(w, http.StatusText(500), 500)
+				return
+			}
+			if ops.Limiter != nil {
+				p.PushNamed("global throttle", ops.Limiter.FilterContext(r.Context()))
 			}
-			p.To(w)
+			p.To(res)
 			if err := p.Exec(); err != nil {
-				http.Error(w, http.StatusText(500), 500)
+				logPipeError(r, err)
+				res.fail(ErrorStatus(err, http.StatusBadGateway, http.StatusInternalServerError))
+			} else {
+				res.flush()
 			}
 		}
 	}
 
+	// head checks that the object exists and sets the headers
+	// of its information if the input is a rw.Stater.
+	head := func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		if ops.Verify != "" && isDigestID(id, []string{ops.Verify}) {
+			http.Error(w, http.StatusText(400), 400)
+		} else if stater, ok := ops.Input.(rw.Stater); ok {
+			if info, err := stater.Stat(id); err != nil {
+				status := statStatus(err)
+				http.Error(w, http.StatusText(status), status)
+			} else {
+				setInfoHeaders(w.Header(), info, ops.Raw())
+				if _, ok := rangeInput(ops, info); ok {
+					w.Header().Set("Accept-Ranges", "bytes")
+				}
+			}
+		} else if reader, err := ops.Input.NewReader(id); err != nil {
+			status := idErrorStatus(err, 404)
+			http.Error(w, http.StatusText(status), status)
+		} else {
+			reader.Close()
+		}
+	}
+
 	r.Get("/{id}", handler)
+	r.Head("/{id}", head)
+}
+
+// responseWriter writes the output of a Pipe in an http.ResponseWriter.
+// The status is only written with the first bytes, so the handler can still
+// reply with an error if the Pipe fails before.
+type responseWriter struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	status  int
+	written bool
+	failed  bool
+}
+
+// Write writes the status if needed and b.
+func (res *responseWriter) Write(b []byte) (int, error) {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	if res.failed {
+		return 0, io.ErrClosedPipe
+	}
+	res.writeHeader()
+	return res.w.Write(b)
+}
+
+func (res *responseWriter) writeHeader() {
+	if !res.written {
+		res.w.WriteHeader(res.status)
+		res.written = true
+	}
+}
+
+// flush writes the status if nothing was written.
+func (res *responseWriter) flush() {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	res.writeHeader()
+}
+
+// fail stops the writes of the Pipe and replies with status. If the
+// response has already started it cannot be changed and the connection
+// is aborted, so the client does not take a truncated body for a
+// complete one.
+func (res *responseWriter) fail(status int) {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	res.failed = true
+	if res.written {
+		panic(http.ErrAbortHandler)
+	}
+	res.w.Header().Del("Content-Range")
+	http.Error(res.w, http.StatusText(status), status)
+}
+
+// rangeInput returns the input of ops as a rw.RangeReader if the
+// parts of the object with info can be sent: the steps of ops should
+// not transform the data and the digest of the object is not verified.
+func rangeInput(ops *ReadOperations, info *rw.ObjectInfo) (rw.RangeReader, bool) {
+	rr, ok := ops.Input.(rw.RangeReader)
+	return rr, ok && info != nil && ops.Raw() && ops.Verify == ""
+}
+
+// SetListHandler sets a chi handler that lists the ids of a Lister,
+// the ids of the stored digests are skipped. The query parameters
+// "prefix", "cursor" and "limit" are passed to Lister.List.
+func SetListHandler(r chi.Router, lister rw.Lister, digests []string) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		limit := 0
+		if l := query.Get("limit"); l != "" {
+			var err error
+			if limit, err = strconv.Atoi(l); err != nil || limit < 0 {
+				http.Error(w, http.StatusText(400), 400)
+				return
+			}
+		}
+
+		page, err := lister.List(query.Get("prefix"), query.Get("cursor"), limit)
+		if err != nil {
+			logPipeError(r, err)
+			status := idErrorStatus(err, 502)
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		ids := page.IDs[:0]
+		for _, id := range page.IDs {
+			if !isDigestID(id, digests) {
+				ids = append(ids, id)
+			}
+		}
+		page.IDs = ids
+
+		if res, err := json.Marshal(page); err != nil {
+			http.Error(w, http.StatusText(500), 500)
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(res)
+		}
+	}
+
+	r.Get("/", handler)
 }
 
 // SetDeleteHandler sets a chi handler for a Deleter.
+// Deleting an object that does not exist succeeds.
 func SetDeleteHandler(r chi.Router, del rw.Deleter) {
 	handler := func(w http.ResponseWriter, r *http.Request) {
 		id := chi.URLParam(r, "id")
-		if err := del.Delete(id); err != nil {
-			http.Error(w, http.StatusText(500), 500)
+		if err := del.Delete(id); err != nil && !errors.Is(err, os.ErrNotExist) {
+			status := idErrorStatus(err, 500)
+			http.Error(w, http.StatusText(status), status)
 		} else {
 			http.Error(w, http.StatusText(204), 204)
 		}
@@ -97,29 +375,77 @@ func SetDeleteHandler(r chi.Router, del rw.Deleter) {
 
 // WriteResponse is returned as a json response on a sucessfull write.
 type WriteResponse struct {
-	ID       string `json:"id"`
-	BytesIn  int64  `json:"bytes_in"`
-	BytesOut int64  `json:"bytes_out"`
+	ID       string      `json:"id"`
+	BytesIn  int64       `json:"bytes_in"`
+	BytesOut int64       `json:"bytes_out"`
+	Stats    *pipe.Stats `json:"stats,omitempty"`
+	// Errors of the tees that failed without failing the write.
+	Errors []string `json:"errors,omitempty"`
+	// Digests of the input by algorithm.
+	Digests map[string]string `json:"digests,omitempty"`
+	// Digests of each output.
+	Outputs []OutputResponse `json:"outputs,omitempty"`
+}
+
+// OutputResponse contains the digests of an output in a WriteResponse.
+type OutputResponse struct {
+	// Branch of the output, empty for the last output of the writer,
+	// see pipe.StageError.
+	Branch  string            `json:"branch"`
+	Digests map[string]string `json:"digests"`
 }
 
 // SetWriteHandler sets a chi router for a Writer.
 func SetWriteHandler(r chi.Router, ops *WriteOperations) {
 	handler := func(id string, w http.ResponseWriter, r *http.Request) {
+		if err := checkDigestID(id, ops.Digests); err != nil {
+			http.Error(w, http.StatusText(400), 400)
+			return
+		}
 		var reader io.Reader
-		if fr, _, err := r.FormFile("file"); err != nil {
+		var contentType string
+		if fr, fh, err := r.FormFile("file"); err != nil {
 			defer r.Body.Close()
 			reader = r.Body
+			contentType = r.Header.Get("Content-Type")
 		} else {
 			defer fr.Close()
 			reader = fr
+			contentType = fh.Header.Get("Content-Type")
 		}
-		p := pipe.New(reader)
-		if err := ops.SetPipe(p, id); err != nil {
-			http.Error(w, http.StatusText(500), 500)
+		p := pipe.NewWithContext(r.Context(), reader)
+		var inputDigests []*pipe.Digest
+		for _, a := range ops.Digests {
+			d, _ := pipe.NewDigest(a)
+			p.PushDigest(d)
+			inputDigests = append(inputDigests, d)
+		}
+		if ops.Limiter != nil {
+			p.PushNamed("global throttle", ops.Limiter.FilterContext(r.Context()))
+		}
+		if outputs, err := ops.SetPipeOutputsWithOptions(p, id, writeOptions(r, contentType)); err != nil {
+			status := idErrorStatus(err, 500)
+			http.Error(w, http.StatusText(status), status)
 		} else if err := p.Exec(); err != nil {
-			http.Error(w, http.StatusText(500), 500)
+			logPipeError(r, err)
+			status := ErrorStatus(err, http.StatusBadRequest, http.StatusBadGateway)
+			http.Error(w, http.StatusText(status), status)
+		} else if err := WriteDigests(outputs, id, p.Detached()); err != nil {
+			logPipeError(r, err)
+			http.Error(w, http.StatusText(502), 502)
 		} else {
-			data := &WriteResponse{id, p.TotalIn, p.TotalOut}
+			stats := p.Stats()
+			data := &WriteResponse{ID: id, BytesIn: p.TotalIn, BytesOut: p.TotalOut, Stats: &stats}
+			for _, err := range p.Detached() {
+				logPipeError(r, err)
+				data.Errors = append(data.Errors, err.Error())
+			}
+			data.Digests = digestsByAlgorithm(inputDigests)
+			for _, out := range outputs {
+				if len(out.Digests) > 0 && !detachedBranch(out.Branch, p.Detached()) {
+					data.Outputs = append(data.Outputs, OutputResponse{out.Branch, digestsByAlgorithm(out.Digests)})
+				}
+			}
 
 			if res, err := json.Marshal(data); err != nil {
 				http.Error(w, http.StatusText(500), 500)
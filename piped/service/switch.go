@@ -0,0 +1,101 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperboloide/pipe"
+)
+
+// Switch is a step that detects the content type of the stream and
+// applies the steps of the case with the matching media type pattern,
+// see pipe.Switch. Cases are defined in the "switch" field as a map of
+// patterns to a list of encoders (in a writer) or decoders (in a reader):
+//
+//	{"switch": {"image/*": [], "*": [{"encoder": "gzip"}]}}
+//
+// The most specific pattern is tried first, if no pattern matches the
+// stream is copied without changes.
+type Switch struct {
+	filterStep
+}
+
+// SwitchFromJSON builds a Switch from json, t is the type of the elements
+// of the cases: "encoder" or "decoder".
+func SwitchFromJSON(js json.RawMessage, t string) (*Switch, error) {
+	tmp := struct {
+		Switch map[string][]json.RawMessage `json:"switch"`
+	}{}
+	if err := json.Unmarshal(js, &tmp); err != nil {
+		return nil, err
+	} else if len(tmp.Switch) == 0 {
+		return nil, errors.New("switch should define at least 1 case")
+	}
+
+	patterns := make([]string, 0, len(tmp.Switch))
+	for p := range tmp.Switch {
+		patterns = append(patterns, p)
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		si, sj := patternSpecificity(patterns[i]), patternSpecificity(patterns[j])
+		if si != sj {
+			return si > sj
+		}
+		return patterns[i] < patterns[j]
+	})
+
+	cases := make([]pipe.Case, len(patterns))
+	for i, p := range patterns {
+		filters, err := switchCaseFilters(tmp.Switch[p], t)
+		if err != nil {
+			return nil, fmt.Errorf("switch case '%s': %s", p, err)
+		}
+		cases[i].Pattern = p
+		if len(filters) > 0 {
+			cases[i].Filter = pipe.Chain(filters...)
+		}
+	}
+	return &Switch{filterStep{pipe.Switch(cases...)}}, nil
+}
+
+// patternSpecificity ranks a media type pattern: a full media type is
+// more specific than a type with any sub type, which is more specific
+// than anything.
+func patternSpecificity(pattern string) int {
+	switch {
+	case pattern == "*" || pattern == "*/*":
+		return 0
+	case strings.HasSuffix(pattern, "/*"):
+		return 1
+	}
+	return 2
+}
+
+// switchCaseFilters builds the filters of the elements of a case.
+func switchCaseFilters(elements []json.RawMessage, t string) ([]pipe.Filter, error) {
+	var filters []pipe.Filter
+	for _, js := range elements {
+		if et, err := GetElementType(js); err != nil {
+			return nil, err
+		} else if et != t {
+			return nil, fmt.Errorf("element of type '%s' is not available inside a switch, use '%s'", et, t)
+		}
+		if t == "encoder" {
+			step, err := NamedEncoderFromJSON(js)
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, step.Filter())
+		} else {
+			step, err := NamedDecoderFromJSON(js)
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, step.Filter())
+		}
+	}
+	return filters, nil
+}
@@ -0,0 +1,85 @@
+package service_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	. "github.com/hyperboloide/pipe/piped/service"
+)
+
+// test ingesting and serving zip archives
+func Test12(t *testing.T) {
+	var config []byte
+
+	// create tmp dirs for testing
+	destDir, err := ioutil.TempDir("", "dest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+	cfg := fmt.Sprintf(string(fileBytes("./test12.json")[:]), destDir, destDir)
+	config = []byte(cfg)
+
+	// creates the server
+	r := RouterFromConfig(config, true)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	const id = "file_id_1234"
+
+	var upload bytes.Buffer
+	zw := zip.NewWriter(&upload)
+	if w, err := zw.Create("upload.jpg"); err != nil {
+		t.Fatal(err)
+	} else if _, err := w.Write(fileBytes(testImageFile)); err != nil {
+		t.Fatal(err)
+	} else if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// post the archive, the entry is extracted
+	if resp, err := http.Post(srv.URL+"/test/"+id, "application/zip", &upload); err != nil {
+		t.Error(err)
+	} else if resp.StatusCode != 201 {
+		t.Error(fmt.Errorf("invalid response status code %d", resp.StatusCode))
+	} else if res, err := ioutil.ReadFile(destDir + "/" + id); err != nil {
+		t.Error(err)
+	} else if !bytes.Equal(res, fileBytes(testImageFile)) {
+		t.Error(errors.New("uploaded file should be extracted"))
+	}
+
+	// get the file as a zip archive
+	resp, err := http.Get(srv.URL + "/test/" + id)
+	if err != nil {
+		t.Fatal(err)
+	} else if resp.StatusCode != 200 {
+		t.Fatal(fmt.Errorf("invalid response status code %d", resp.StatusCode))
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(zr.File) != 1 || zr.File[0].Name != "test.jpg" {
+		t.Fatal(errors.New("archive should contain test.jpg"))
+	}
+	f, err := zr.File[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if content, err := ioutil.ReadAll(f); err != nil {
+		t.Error(err)
+	} else if !bytes.Equal(content, fileBytes(testImageFile)) {
+		t.Error(errors.New("downloaded entry do not match the original"))
+	}
+}
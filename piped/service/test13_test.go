@@ -0,0 +1,49 @@
+package service_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	. "github.com/hyperboloide/pipe/piped/service"
+)
+
+// test text filters
+func Test13(t *testing.T) {
+	var config []byte
+
+	// create tmp dirs for testing
+	destDir, err := ioutil.TempDir("", "dest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+	cfg := fmt.Sprintf(string(fileBytes("./test13.json")[:]), destDir)
+	config = []byte(cfg)
+
+	// creates the server
+	r := RouterFromConfig(config, true)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	const id = "file_id_1234"
+	logs := "INFO login bob@example.com password=secret\r\n" +
+		"DEBUG query\r\n" +
+		"INFO paid with 4111-1111-1111-1111\r\n"
+	expected := "INFO login *** password=***\n" +
+		"INFO paid with ***\n"
+
+	if resp, err := http.Post(srv.URL+"/logs/"+id, "text/plain", strings.NewReader(logs)); err != nil {
+		t.Error(err)
+	} else if resp.StatusCode != 201 {
+		t.Error(fmt.Errorf("invalid response status code %d", resp.StatusCode))
+	} else if res, err := ioutil.ReadFile(destDir + "/" + id); err != nil {
+		t.Error(err)
+	} else if string(res) != expected {
+		t.Errorf("expected %q, got %q", expected, string(res))
+	}
+}
@@ -0,0 +1,56 @@
+package service_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	. "github.com/hyperboloide/pipe/piped/service"
+)
+
+// test serving a csv file as json lines
+func Test14(t *testing.T) {
+	var config []byte
+
+	// create tmp dirs for testing
+	destDir, err := ioutil.TempDir("", "dest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+	cfg := fmt.Sprintf(string(fileBytes("./test14.json")[:]), destDir, destDir)
+	config = []byte(cfg)
+
+	// creates the server
+	r := RouterFromConfig(config, true)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	const id = "file_id_1234"
+	csv := "name,age,city\nalice,34,Paris\nbob,27,London\ncarol,41,Berlin\n"
+	expected := `{"name":"alice","city":"Paris"}` + "\n" +
+		`{"name":"carol","city":"Berlin"}` + "\n"
+
+	if resp, err := http.Post(srv.URL+"/people/"+id, "text/csv", strings.NewReader(csv)); err != nil {
+		t.Fatal(err)
+	} else if resp.StatusCode != 201 {
+		t.Fatal(fmt.Errorf("invalid response status code %d", resp.StatusCode))
+	}
+
+	resp, err := http.Get(srv.URL + "/people/" + id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatal(fmt.Errorf("invalid response status code %d", resp.StatusCode))
+	} else if res, err := ioutil.ReadAll(resp.Body); err != nil {
+		t.Error(err)
+	} else if string(res) != expected {
+		t.Errorf("expected %q, got %q", expected, string(res))
+	}
+}
@@ -0,0 +1,74 @@
+package service_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/hyperboloide/pipe/piped/service"
+)
+
+// test that a failed upload keeps the previous version of the file
+func Test15(t *testing.T) {
+	var config []byte
+
+	// create tmp dirs for testing
+	destDir, err := ioutil.TempDir("", "dest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+	cfg := fmt.Sprintf(string(fileBytes("./test15.json")[:]), destDir)
+	config = []byte(cfg)
+
+	// creates the server
+	r := RouterFromConfig(config, true)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	const id = "file_id_1234"
+
+	var good bytes.Buffer
+	gz := gzip.NewWriter(&good)
+	gz.Write(fileBytes(testImageFile))
+	gz.Close()
+
+	if resp, err := http.Post(srv.URL+"/test/"+id, "application/gzip", &good); err != nil {
+		t.Fatal(err)
+	} else if resp.StatusCode != 201 {
+		t.Fatal(fmt.Errorf("invalid response status code %d", resp.StatusCode))
+	}
+
+	// a corrupted upload fails
+	corrupted := append(good.Bytes()[:100:100], strings.Repeat("x", 1000)...)
+	if resp, err := http.Post(srv.URL+"/test/"+id, "application/gzip", bytes.NewReader(corrupted)); err != nil {
+		t.Fatal(err)
+	} else if resp.StatusCode == 201 {
+		t.Fatal("corrupted upload should fail")
+	}
+
+	if res, err := ioutil.ReadFile(destDir + "/" + id); err != nil {
+		t.Error(err)
+	} else if !bytes.Equal(res, fileBytes(testImageFile)) {
+		t.Error("failed upload should keep the previous file")
+	}
+
+	// the temporary file is removed when the writer is aborted
+	for i := 0; ; i++ {
+		if files, err := ioutil.ReadDir(destDir); err != nil {
+			t.Fatal(err)
+		} else if len(files) == 1 {
+			break
+		} else if i == 100 {
+			t.Fatalf("temporary file should be removed, found %d files", len(files))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
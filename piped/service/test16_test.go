@@ -0,0 +1,57 @@
+package service_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/hyperboloide/pipe/piped/service"
+)
+
+// test that invalid ids are rejected
+func Test16(t *testing.T) {
+	var config []byte
+
+	// create tmp dirs for testing
+	rootDir, err := ioutil.TempDir("", "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(rootDir)
+	destDir := filepath.Join(rootDir, "dest")
+	cfg := fmt.Sprintf(string(fileBytes("./test16.json")[:]), destDir, destDir, destDir)
+	config = []byte(cfg)
+
+	// a file outside of the directory
+	if err := ioutil.WriteFile(filepath.Join(rootDir, "secret"), []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// creates the server
+	r := RouterFromConfig(config, true)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	ids := []string{"..%2Fsecret", ".hidden", "a%20b", strings.Repeat("a", 300)}
+	for _, id := range ids {
+		for _, method := range []string{"GET", "POST", "DELETE"} {
+			req, err := http.NewRequest(method, srv.URL+"/test/"+id, strings.NewReader("data"))
+			if err != nil {
+				t.Fatal(err)
+			} else if resp, err := http.DefaultClient.Do(req); err != nil {
+				t.Error(err)
+			} else if resp.StatusCode != 400 {
+				t.Errorf("%s of %q: invalid response status code %d", method, id, resp.StatusCode)
+			}
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(rootDir, "secret")); err != nil {
+		t.Error("file outside of the directory should not be deleted")
+	}
+}
@@ -0,0 +1,82 @@
+package service_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	. "github.com/hyperboloide/pipe/piped/service"
+	"github.com/hyperboloide/pipe/rw"
+)
+
+// test listing the ids of a route
+func Test17(t *testing.T) {
+	var config []byte
+
+	// create tmp dirs for testing
+	destDir, err := ioutil.TempDir("", "dest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+	cfg := fmt.Sprintf(string(fileBytes("./test17.json")[:]), destDir, destDir)
+	config = []byte(cfg)
+
+	// creates the server
+	r := RouterFromConfig(config, true)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	for _, id := range []string{"log_1", "log_2", "log_3", "other"} {
+		if resp, err := http.Post(srv.URL+"/test/"+id, "text/plain", strings.NewReader(id)); err != nil {
+			t.Fatal(err)
+		} else if resp.StatusCode != 201 {
+			t.Fatal(fmt.Errorf("invalid response status code %d", resp.StatusCode))
+		}
+	}
+
+	list := func(query url.Values) (*rw.Page, int) {
+		resp, err := http.Get(srv.URL + "/test/?" + query.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		page := &rw.Page{}
+		if resp.StatusCode == 200 {
+			if err := json.NewDecoder(resp.Body).Decode(page); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return page, resp.StatusCode
+	}
+
+	// list all the pages, the digests are not listed
+	var ids []string
+	query := url.Values{"prefix": {"log_"}, "limit": {"2"}}
+	for pages := 1; ; pages++ {
+		page, status := list(query)
+		if status != 200 {
+			t.Fatal(fmt.Errorf("invalid response status code %d", status))
+		} else if pages > 10 {
+			t.Fatal("too many pages")
+		}
+		ids = append(ids, page.IDs...)
+		if page.Next == "" {
+			break
+		}
+		query.Set("cursor", page.Next)
+	}
+	if strings.Join(ids, ",") != "log_1,log_2,log_3" {
+		t.Errorf("invalid ids: %v", ids)
+	}
+
+	if _, status := list(url.Values{"limit": {"x"}}); status != 400 {
+		t.Errorf("invalid response status code %d", status)
+	}
+}
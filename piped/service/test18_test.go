@@ -0,0 +1,91 @@
+package service_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/hyperboloide/pipe/piped/service"
+)
+
+// test the object information in HEAD and GET responses
+func Test18(t *testing.T) {
+	var config []byte
+
+	// create tmp dirs for testing
+	destDir, err := ioutil.TempDir("", "dest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+	cfg := fmt.Sprintf(string(fileBytes("./test18.json")[:]), destDir, destDir, destDir, destDir)
+	config = []byte(cfg)
+
+	// creates the server
+	r := RouterFromConfig(config, true)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	const id = "file_id_1234"
+	const data = "some text data"
+
+	for _, url := range []string{"/raw/", "/gz/"} {
+		req, err := http.NewRequest("POST", srv.URL+url+id, strings.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set(MetadataHeaderPrefix+"Owner", "bob")
+		if resp, err := http.DefaultClient.Do(req); err != nil {
+			t.Fatal(err)
+		} else if resp.StatusCode != 201 {
+			t.Fatal(fmt.Errorf("invalid response status code %d", resp.StatusCode))
+		}
+	}
+
+	// the raw object has all the headers
+	for _, method := range []string{"HEAD", "GET"} {
+		req, _ := http.NewRequest(method, srv.URL+"/raw/"+id, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.Errorf("%s: invalid response status code %d", method, resp.StatusCode)
+		} else if resp.Header.Get("Content-Length") != strconv.Itoa(len(data)) {
+			t.Errorf("%s: invalid Content-Length %q", method, resp.Header.Get("Content-Length"))
+		} else if resp.Header.Get("Content-Type") != "text/plain" {
+			t.Errorf("%s: invalid Content-Type %q", method, resp.Header.Get("Content-Type"))
+		} else if resp.Header.Get(MetadataHeaderPrefix+"Owner") != "bob" {
+			t.Errorf("%s: metadata should be returned", method)
+		} else if lm, err := http.ParseTime(resp.Header.Get("Last-Modified")); err != nil {
+			t.Error(err)
+		} else if time.Since(lm) > time.Minute {
+			t.Errorf("%s: invalid Last-Modified %s", method, lm)
+		}
+	}
+
+	// the decoded object has no length and content type
+	if resp, err := http.Head(srv.URL + "/gz/" + id); err != nil {
+		t.Fatal(err)
+	} else if resp.StatusCode != 200 {
+		t.Errorf("invalid response status code %d", resp.StatusCode)
+	} else if resp.Header.Get("Content-Length") != "" || resp.Header.Get("Content-Type") == "text/plain" {
+		t.Error("headers of the stored object should not be returned for a decoded object")
+	} else if resp.Header.Get(MetadataHeaderPrefix+"Owner") != "bob" {
+		t.Error("metadata should be returned")
+	}
+
+	if resp, err := http.Head(srv.URL + "/raw/missing"); err != nil {
+		t.Fatal(err)
+	} else if resp.StatusCode != 404 {
+		t.Errorf("invalid response status code %d", resp.StatusCode)
+	}
+}
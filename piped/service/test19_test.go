@@ -0,0 +1,120 @@
+package service_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	. "github.com/hyperboloide/pipe/piped/service"
+)
+
+// test range requests
+func Test19(t *testing.T) {
+	var config []byte
+
+	// create tmp dirs for testing
+	destDir, err := ioutil.TempDir("", "dest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+	cfg := fmt.Sprintf(string(fileBytes("./test19.json")[:]), destDir, destDir, destDir, destDir)
+	config = []byte(cfg)
+
+	// creates the server
+	r := RouterFromConfig(config, true)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	const id = "file_id_1234"
+	data := fileBytes(testImageFile)
+	size := len(data)
+
+	for _, url := range []string{"/raw/", "/gz/"} {
+		if resp, err := http.Post(srv.URL+url+id, "image/jpeg", bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		} else if resp.StatusCode != 201 {
+			t.Fatal(fmt.Errorf("invalid response status code %d", resp.StatusCode))
+		}
+	}
+
+	head, err := http.Head(srv.URL + "/raw/" + id)
+	if err != nil {
+		t.Fatal(err)
+	} else if head.Header.Get("Accept-Ranges") != "bytes" {
+		t.Error("ranges should be accepted")
+	}
+
+	get := func(url string, headers map[string]string) (*http.Response, []byte) {
+		req, _ := http.NewRequest("GET", srv.URL+url+id, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, body
+	}
+
+	cases := []struct {
+		url          string
+		headers      map[string]string
+		status       int
+		contentRange string
+		body         []byte
+	}{
+		{"/raw/", map[string]string{"Range": "bytes=0-9"}, 206, fmt.Sprintf("bytes 0-9/%d", size), data[:10]},
+		{"/raw/", map[string]string{"Range": "bytes=100-"}, 206, fmt.Sprintf("bytes 100-%d/%d", size-1, size), data[100:]},
+		{"/raw/", map[string]string{"Range": "bytes=-5"}, 206, fmt.Sprintf("bytes %d-%d/%d", size-5, size-1, size), data[size-5:]},
+		{"/raw/", map[string]string{"Range": fmt.Sprintf("bytes=%d-", size)}, 416, fmt.Sprintf("bytes */%d", size), nil},
+		{"/raw/", map[string]string{"Range": "bytes=0-1,5-6"}, 200, "", data},
+		{"/raw/", map[string]string{"Range": "bytes=0-9", "If-Range": head.Header.Get("ETag")}, 206, fmt.Sprintf("bytes 0-9/%d", size), data[:10]},
+		{"/raw/", map[string]string{"Range": "bytes=0-9", "If-Range": head.Header.Get("Last-Modified")}, 206, fmt.Sprintf("bytes 0-9/%d", size), data[:10]},
+		{"/raw/", map[string]string{"Range": "bytes=0-9", "If-Range": `"other"`}, 200, "", data},
+		{"/gz/", map[string]string{"Range": "bytes=0-9"}, 200, "", data},
+	}
+	for i, c := range cases {
+		resp, body := get(c.url, c.headers)
+		if resp.StatusCode != c.status {
+			t.Errorf("case %d: invalid response status code %d", i, resp.StatusCode)
+		} else if resp.Header.Get("Content-Range") != c.contentRange {
+			t.Errorf("case %d: invalid Content-Range %q", i, resp.Header.Get("Content-Range"))
+		} else if c.body != nil && !bytes.Equal(body, c.body) {
+			t.Errorf("case %d: invalid body of %d bytes", i, len(body))
+		}
+	}
+
+	// a failure after the start of the response aborts the connection,
+	// a failure before replies with an error status.
+	gz, err := ioutil.ReadFile(destDir + "/" + id + ".gz")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := ioutil.WriteFile(destDir+"/"+id+".gz", gz[:len(gz)/2], 0600); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := http.Get(srv.URL + "/gz/" + id); err != nil {
+		t.Fatal(err)
+	} else {
+		_, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err == nil {
+			t.Error("a truncated response should fail")
+		}
+	}
+	if err := ioutil.WriteFile(destDir+"/"+id+".gz", []byte("not gzip"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ := get("/gz/", nil); resp.StatusCode != 500 {
+		t.Errorf("invalid response status code %d", resp.StatusCode)
+	}
+}
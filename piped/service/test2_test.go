@@ -2,6 +2,7 @@ package service_test
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -34,6 +35,8 @@ func Test2(t *testing.T) {
 
 	const id = "file_id_1234"
 
+	data := &WriteResponse{}
+
 	// post the file
 	if resp, err := http.Post(srv.URL+"/test/"+id, "image/jpeg", fileReader(testImageFile)); err != nil {
 		t.Error(err)
@@ -43,6 +46,12 @@ func Test2(t *testing.T) {
 		t.Error(err)
 	} else if !bytes.Equal(res, fileBytes(testImageFile)) {
 		t.Error(errors.New("uploaded file do not match the original"))
+	} else if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		t.Error(err)
+	} else if data.Stats == nil || len(data.Stats.Tees) != 1 {
+		t.Error(errors.New("response should have the stats of the tee"))
+	} else if gz := data.Stats.Tees[0].Stages[1]; gz.Name != "encoder gzip" || gz.BytesIn != data.BytesIn {
+		t.Error(fmt.Errorf("invalid stats for the gzip encoder: %+v", gz))
 	}
 
 	// get the original file
@@ -0,0 +1,106 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hyperboloide/pipe"
+	. "github.com/hyperboloide/pipe/piped/service"
+)
+
+// test a best effort tee that fails, parallel encoders and throttles
+func Test3(t *testing.T) {
+	var config []byte
+
+	// create tmp dirs for testing
+	destDir, err := ioutil.TempDir("", "dest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+	teeDir, err := ioutil.TempDir("", "tee")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := fmt.Sprintf(string(fileBytes("./test3.json")[:]), teeDir, destDir, destDir, destDir)
+	config = []byte(cfg)
+
+	// creates the server, every request shares a limiter
+	r := RouterFromConfigWithOptions(config, RouterOptions{
+		Silent:  true,
+		Limiter: pipe.NewLimiter(100<<20, 0),
+	})
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	// the tee cannot write anymore
+	os.RemoveAll(teeDir)
+
+	const id = "file_id_1234"
+	data := &WriteResponse{}
+
+	// post the file
+	if resp, err := http.Post(srv.URL+"/test/"+id, "image/jpeg", fileReader(testImageFile)); err != nil {
+		t.Error(err)
+	} else if resp.StatusCode != 201 {
+		t.Error(fmt.Errorf("invalid response status code %d", resp.StatusCode))
+	} else if res, err := ioutil.ReadFile(destDir + "/" + id); err != nil {
+		t.Error(err)
+	} else if !bytes.Equal(res, fileBytes(testImageFile)) {
+		t.Error(errors.New("uploaded file do not match the original"))
+	} else if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		t.Error(err)
+	} else if len(data.Errors) != 1 {
+		t.Error(errors.New("response should report the error of the tee"))
+	}
+
+	// post and get with a parallel encoder and decoder
+	if resp, err := http.Post(srv.URL+"/parallel/"+id, "image/jpeg", fileReader(testImageFile)); err != nil {
+		t.Error(err)
+	} else if resp.StatusCode != 201 {
+		t.Error(fmt.Errorf("invalid response status code %d", resp.StatusCode))
+	} else if resp, err := http.Get(srv.URL + "/parallel/" + id); err != nil {
+		t.Error(err)
+	} else if resp.StatusCode != 200 {
+		t.Error(fmt.Errorf("invalid response status code %d", resp.StatusCode))
+	} else if body, err := ioutil.ReadAll(resp.Body); err != nil {
+		t.Error(err)
+	} else if !bytes.Equal(body, fileBytes(testImageFile)) {
+		t.Error(errors.New("downloaded file do not match the original"))
+	}
+}
+
+// a throttle stops waiting when the context of the pipe is done
+func TestThrottleContext(t *testing.T) {
+	ops := &ReadOperations{}
+	if err := ops.AddStep(json.RawMessage(`{"throttle": 1}`)); err != nil {
+		t.Fatal(err)
+	}
+	step, ok := ops.Steps[0].(*NamedDecoder)
+	if !ok {
+		t.Fatal("the throttle should be a decoder")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	filter := step.FilterContext(ctx)
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	done := make(chan error, 1)
+	go func() { done <- filter(bytes.NewReader(make([]byte, 10)), ioutil.Discard) }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("throttle should return the context error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("throttle should stop when the context is canceled")
+	}
+}
@@ -0,0 +1,141 @@
+package service_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	. "github.com/hyperboloide/pipe/piped/service"
+)
+
+// test digests
+func Test4(t *testing.T) {
+	var config []byte
+
+	// create tmp dirs for testing
+	destDir, err := ioutil.TempDir("", "dest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+	cfg := fmt.Sprintf(string(fileBytes("./test4.json")[:]), destDir, destDir, destDir, destDir, destDir)
+	config = []byte(cfg)
+
+	// creates the server
+	r := RouterFromConfig(config, true)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	const id = "file_id_1234"
+	data := &WriteResponse{}
+	sum := sha256.Sum256(fileBytes(testImageFile))
+	expected := hex.EncodeToString(sum[:])
+
+	// post the file
+	if resp, err := http.Post(srv.URL+"/test/"+id, "image/jpeg", fileReader(testImageFile)); err != nil {
+		t.Error(err)
+	} else if resp.StatusCode != 201 {
+		t.Error(fmt.Errorf("invalid response status code %d", resp.StatusCode))
+	} else if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		t.Error(err)
+	} else if data.Digests["sha256"] != expected || data.Digests["md5"] == "" {
+		t.Error(fmt.Errorf("invalid input digests %v", data.Digests))
+	} else if len(data.Outputs) != 2 || data.Outputs[0].Digests["sha256"] != expected {
+		t.Error(fmt.Errorf("invalid output digests %v", data.Outputs))
+	} else if data.Outputs[1].Branch != "0" || data.Outputs[1].Digests["sha256"] == expected {
+		t.Error(fmt.Errorf("invalid tee digests %v", data.Outputs[1]))
+	} else if stored := fileBytes(destDir + "/" + id + ".sha256"); string(stored) != expected {
+		t.Error(errors.New("digest not stored"))
+	}
+
+	// get the verified file
+	if resp, err := http.Get(srv.URL + "/test/" + id); err != nil {
+		t.Error(err)
+	} else if resp.StatusCode != 200 {
+		t.Error(fmt.Errorf("invalid response status code %d", resp.StatusCode))
+	} else if body, err := ioutil.ReadAll(resp.Body); err != nil {
+		t.Error(err)
+	} else if !bytes.Equal(body, fileBytes(testImageFile)) {
+		t.Error(errors.New("downloaded file do not match the original"))
+	}
+
+	// tamper the file
+	if err := ioutil.WriteFile(destDir+"/"+id, []byte("something else"), 0600); err != nil {
+		t.Error(err)
+	} else if resp, err := http.Get(srv.URL + "/test/" + id); err != nil {
+		t.Error(err)
+	} else if resp.StatusCode != 500 {
+		t.Error(fmt.Errorf("invalid response status code %d", resp.StatusCode))
+	}
+
+	// a tampered file larger than what the verifier holds back is
+	// verified while it is sent: the response starts and is cut off
+	// before its end.
+	tampered := fileBytes(testImageFile)
+	tampered[0]++
+	if err := ioutil.WriteFile(destDir+"/"+id, tampered, 0600); err != nil {
+		t.Error(err)
+	} else if resp, err := http.Get(srv.URL + "/test/" + id); err != nil {
+		t.Error(err)
+	} else {
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.Error(fmt.Errorf("invalid response status code %d", resp.StatusCode))
+		} else if err == nil || len(body) >= len(tampered) {
+			t.Errorf("a tampered file should be cut off, got %d bytes", len(body))
+		}
+	}
+
+	// a missing object is not found, an object without digest
+	// cannot be verified unless the route allows it.
+	if err := ioutil.WriteFile(destDir+"/legacy_id", []byte("legacy"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		url    string
+		status int
+	}{
+		{"/test/missing_id", 404},
+		{"/test/legacy_id", 409},
+		{"/legacy/missing_id", 404},
+		{"/legacy/legacy_id", 200},
+	} {
+		if resp, err := http.Get(srv.URL + c.url); err != nil {
+			t.Error(err)
+		} else if resp.StatusCode != c.status {
+			t.Errorf("%s: invalid response status code %d", c.url, resp.StatusCode)
+		}
+	}
+
+	// the ids of the digests are reserved.
+	if resp, err := http.Post(srv.URL+"/test/"+id+".sha256", "text/plain", bytes.NewReader([]byte(expected))); err != nil {
+		t.Error(err)
+	} else if resp.StatusCode != 400 {
+		t.Error(fmt.Errorf("invalid response status code %d", resp.StatusCode))
+	} else if resp, err := http.Get(srv.URL + "/test/" + id + ".sha256"); err != nil {
+		t.Error(err)
+	} else if resp.StatusCode != 400 {
+		t.Error(fmt.Errorf("invalid response status code %d", resp.StatusCode))
+	}
+
+	// delete the file and its digests
+	client := &http.Client{}
+	if req, err := http.NewRequest("DELETE", srv.URL+"/test/"+id, nil); err != nil {
+		t.Error(err)
+	} else if resp, err := client.Do(req); err != nil {
+		t.Error(err)
+	} else if resp.StatusCode != 204 {
+		t.Error(fmt.Errorf("invalid response status code %d", resp.StatusCode))
+	} else if _, err := os.Stat(destDir + "/" + id + ".sha256"); !os.IsNotExist(err) {
+		t.Error(errors.New("digest not deleted"))
+	}
+}
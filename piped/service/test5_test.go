@@ -0,0 +1,71 @@
+package service_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	. "github.com/hyperboloide/pipe/piped/service"
+)
+
+// test chains of encoders
+func Test5(t *testing.T) {
+	var config []byte
+
+	// create tmp dirs for testing
+	destDir, err := ioutil.TempDir("", "dest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+	cfg := fmt.Sprintf(string(fileBytes("./test5.json")[:]), destDir, destDir)
+	config = []byte(cfg)
+
+	// creates the server
+	r := RouterFromConfig(config, true)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	const id = "file_id_1234"
+
+	// post the file
+	if resp, err := http.Post(srv.URL+"/test/"+id, "image/jpeg", fileReader(testImageFile)); err != nil {
+		t.Error(err)
+	} else if resp.StatusCode != 201 {
+		t.Error(fmt.Errorf("invalid response status code %d", resp.StatusCode))
+	} else if res, err := ioutil.ReadFile(destDir + "/" + id); err != nil {
+		t.Error(err)
+	} else if bytes.Equal(res, fileBytes(testImageFile)) {
+		t.Error(errors.New("uploaded file should be encoded"))
+	}
+
+	// get the file
+	if resp, err := http.Get(srv.URL + "/test/" + id); err != nil {
+		t.Error(err)
+	} else if resp.StatusCode != 200 {
+		t.Error(fmt.Errorf("invalid response status code %d", resp.StatusCode))
+	} else if body, err := ioutil.ReadAll(resp.Body); err != nil {
+		t.Error(err)
+	} else if !bytes.Equal(body, fileBytes(testImageFile)) {
+		t.Error(errors.New("downloaded file do not match the original"))
+	}
+
+	// decoders that cannot encode are refused in writers.
+	for _, js := range []string{
+		`{"encoder": "bzip2"}`,
+		`{"encoder": "auto"}`,
+		`{"encoder": "chain", "chain": [{"encoder": "gzip"}, {"encoder": "bzip2"}]}`,
+	} {
+		if _, err := EncoderFromJSON([]byte(js)); err == nil {
+			t.Errorf("%s should be refused as an encoder", js)
+		}
+	}
+	if _, err := DecoderFromJSON([]byte(`{"decoder": "bzip2"}`)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,66 @@
+package service_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	. "github.com/hyperboloide/pipe/piped/service"
+)
+
+// test switch on the content type
+func Test6(t *testing.T) {
+	var config []byte
+
+	// create tmp dirs for testing
+	destDir, err := ioutil.TempDir("", "dest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+	cfg := fmt.Sprintf(string(fileBytes("./test6.json")[:]), destDir, destDir)
+	config = []byte(cfg)
+
+	// creates the server
+	r := RouterFromConfig(config, true)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	files := []struct {
+		id      string
+		file    string
+		encoded bool
+	}{
+		{"image_id", testImageFile, false},
+		{"text_id", testTextFile, true},
+	}
+
+	for _, f := range files {
+		// post the file
+		if resp, err := http.Post(srv.URL+"/test/"+f.id, "application/octet-stream", fileReader(f.file)); err != nil {
+			t.Error(err)
+		} else if resp.StatusCode != 201 {
+			t.Error(fmt.Errorf("invalid response status code %d", resp.StatusCode))
+		} else if res, err := ioutil.ReadFile(destDir + "/" + f.id); err != nil {
+			t.Error(err)
+		} else if bytes.Equal(res, fileBytes(f.file)) == f.encoded {
+			t.Error(fmt.Errorf("uploaded file %s should be encoded: %t", f.file, f.encoded))
+		}
+
+		// get the file
+		if resp, err := http.Get(srv.URL + "/test/" + f.id); err != nil {
+			t.Error(err)
+		} else if resp.StatusCode != 200 {
+			t.Error(fmt.Errorf("invalid response status code %d", resp.StatusCode))
+		} else if body, err := ioutil.ReadAll(resp.Body); err != nil {
+			t.Error(err)
+		} else if !bytes.Equal(body, fileBytes(f.file)) {
+			t.Error(errors.New("downloaded file do not match the original"))
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package service_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/hyperboloide/pipe/encoders"
+	. "github.com/hyperboloide/pipe/piped/service"
+)
+
+// test envelopes and the auto decoder
+func Test7(t *testing.T) {
+	var config []byte
+
+	// create tmp dirs for testing
+	destDir, err := ioutil.TempDir("", "dest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+	cfg := fmt.Sprintf(string(fileBytes("./test7.json")[:]), destDir, destDir, destDir)
+	config = []byte(cfg)
+
+	// creates the server
+	r := RouterFromConfig(config, true)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	// an object written with envelopes and an object written without.
+	files := []struct {
+		url     string
+		id      string
+		encoded bool
+	}{
+		{"/test/", "enveloped_id", true},
+		{"/raw/", "raw_id", false},
+	}
+
+	for _, f := range files {
+		if resp, err := http.Post(srv.URL+f.url+f.id, "image/jpeg", fileReader(testImageFile)); err != nil {
+			t.Error(err)
+		} else if resp.StatusCode != 201 {
+			t.Error(fmt.Errorf("invalid response status code %d", resp.StatusCode))
+		} else if res, err := ioutil.ReadFile(destDir + "/" + f.id); err != nil {
+			t.Error(err)
+		} else if bytes.Equal(res, fileBytes(testImageFile)) == f.encoded {
+			t.Error(fmt.Errorf("uploaded file %s should be encoded: %t", f.id, f.encoded))
+		}
+
+		// both are read by the auto decoder.
+		if resp, err := http.Get(srv.URL + "/test/" + f.id); err != nil {
+			t.Error(err)
+		} else if resp.StatusCode != 200 {
+			t.Error(fmt.Errorf("invalid response status code %d", resp.StatusCode))
+		} else if body, err := ioutil.ReadAll(resp.Body); err != nil {
+			t.Error(err)
+		} else if !bytes.Equal(body, fileBytes(testImageFile)) {
+			t.Error(errors.New("downloaded file do not match the original"))
+		}
+	}
+
+	// envelopes are read from the stream and cannot configure the decoders.
+	crafted := []encoders.Layer{
+		{Type: "aesgcm", Version: 1, Params: map[string]interface{}{"key_file": "/etc/passwd"}},
+		{Type: "kms", Version: 1, Params: map[string]interface{}{
+			"provider": "http",
+			"http":     map[string]interface{}{"url": srv.URL + "/raw/leak"},
+		}},
+		{Type: "aesgcm", Version: 1, Params: map[string]interface{}{"key_id": "unknown"}},
+	}
+	for i, l := range crafted {
+		buf := &bytes.Buffer{}
+		if err := encoders.WriteEnvelope(buf, l); err != nil {
+			t.Fatal(err)
+		}
+		buf.Write(fileBytes(testImageFile))
+		id := fmt.Sprintf("crafted_%d", i)
+		if err := ioutil.WriteFile(destDir+"/"+id, buf.Bytes(), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if resp, err := http.Get(srv.URL + "/test/" + id); err != nil {
+			t.Error(err)
+		} else if resp.StatusCode == 200 {
+			t.Errorf("envelope %s with params %v should be refused", l.Type, l.Params)
+		}
+	}
+	if _, err := os.Stat(destDir + "/leak"); !os.IsNotExist(err) {
+		t.Error("the kms of a crafted envelope should not be called")
+	}
+
+	// data that starts with an envelope is not decoded as an envelope.
+	plain := &bytes.Buffer{}
+	if err := encoders.WriteEnvelope(plain, encoders.Layer{Type: "gzip", Version: 1}); err != nil {
+		t.Fatal(err)
+	}
+	plain.Write(fileBytes(testImageFile))
+	if resp, err := http.Post(srv.URL+"/test/penv_id", "application/octet-stream", bytes.NewReader(plain.Bytes())); err != nil {
+		t.Error(err)
+	} else if resp.StatusCode != 201 {
+		t.Error(fmt.Errorf("invalid response status code %d", resp.StatusCode))
+	} else if resp, err := http.Get(srv.URL + "/test/penv_id"); err != nil {
+		t.Error(err)
+	} else if resp.StatusCode != 200 {
+		t.Error(fmt.Errorf("invalid response status code %d", resp.StatusCode))
+	} else if body, err := ioutil.ReadAll(resp.Body); err != nil {
+		t.Error(err)
+	} else if !bytes.Equal(body, plain.Bytes()) {
+		t.Error(errors.New("data starting with an envelope should be read unchanged"))
+	}
+}
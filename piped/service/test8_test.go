@@ -0,0 +1,67 @@
+package service_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	. "github.com/hyperboloide/pipe/piped/service"
+)
+
+// test key rotation with a key file
+func Test8(t *testing.T) {
+	var config []byte
+
+	// create tmp dirs for testing
+	destDir, err := ioutil.TempDir("", "dest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+
+	keyFile := destDir + "/keys.json"
+	keys := `{
+		"keys": {
+			"k1": "l2CijrWFXB2qeKgxlsIqrypKylKWTLnDB8/Joujcjsw=",
+			"k2": "JuLpA6M2yBO5ru0cMNaB0OqX1LjcRZXGYP7jsr7hXKE="
+		},
+		"active": "k2"
+	}`
+	if err := ioutil.WriteFile(keyFile, []byte(keys), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := fmt.Sprintf(string(fileBytes("./test8.json")[:]), destDir, keyFile, destDir, destDir, keyFile)
+	config = []byte(cfg)
+
+	// creates the server
+	r := RouterFromConfig(config, true)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	// v1 encrypts with k1, v2 with k2
+	for _, url := range []string{"/v1/", "/v2/"} {
+		id := "file_id" + url[1:3]
+		if resp, err := http.Post(srv.URL+url+id, "image/jpeg", fileReader(testImageFile)); err != nil {
+			t.Error(err)
+		} else if resp.StatusCode != 201 {
+			t.Error(fmt.Errorf("invalid response status code %d", resp.StatusCode))
+		}
+
+		// both are read with the rotated keys.
+		if resp, err := http.Get(srv.URL + "/v2/" + id); err != nil {
+			t.Error(err)
+		} else if resp.StatusCode != 200 {
+			t.Error(fmt.Errorf("invalid response status code %d", resp.StatusCode))
+		} else if body, err := ioutil.ReadAll(resp.Body); err != nil {
+			t.Error(err)
+		} else if !bytes.Equal(body, fileBytes(testImageFile)) {
+			t.Error(errors.New("downloaded file do not match the original"))
+		}
+	}
+}
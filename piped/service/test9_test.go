@@ -0,0 +1,70 @@
+package service_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/hyperboloide/pipe/encoders/keyring"
+	"github.com/hyperboloide/pipe/encoders/kms"
+	. "github.com/hyperboloide/pipe/piped/service"
+)
+
+// test envelope encryption with an http kms
+func Test9(t *testing.T) {
+	var config []byte
+
+	// create tmp dirs for testing
+	destDir, err := ioutil.TempDir("", "dest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+
+	// local stand-in of the kms
+	local := &kms.LocalProvider{Keyring: keyring.Keyring{
+		KeysB64: map[string]string{"k1": "l2CijrWFXB2qeKgxlsIqrypKylKWTLnDB8/Joujcjsw="},
+	}}
+	if err := local.Start(); err != nil {
+		t.Fatal(err)
+	}
+	kmsSrv := httptest.NewServer(kms.Handler(local, "secret"))
+	defer kmsSrv.Close()
+
+	cfg := fmt.Sprintf(string(fileBytes("./test9.json")[:]), kmsSrv.URL, destDir, destDir, kmsSrv.URL)
+	config = []byte(cfg)
+
+	// creates the server
+	r := RouterFromConfig(config, true)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	const id = "file_id_1234"
+
+	// post the file
+	if resp, err := http.Post(srv.URL+"/test/"+id, "image/jpeg", fileReader(testImageFile)); err != nil {
+		t.Error(err)
+	} else if resp.StatusCode != 201 {
+		t.Error(fmt.Errorf("invalid response status code %d", resp.StatusCode))
+	} else if res, err := ioutil.ReadFile(destDir + "/" + id); err != nil {
+		t.Error(err)
+	} else if bytes.Equal(res, fileBytes(testImageFile)) {
+		t.Error(errors.New("uploaded file should be encoded"))
+	}
+
+	// get the file
+	if resp, err := http.Get(srv.URL + "/test/" + id); err != nil {
+		t.Error(err)
+	} else if resp.StatusCode != 200 {
+		t.Error(fmt.Errorf("invalid response status code %d", resp.StatusCode))
+	} else if body, err := ioutil.ReadAll(resp.Body); err != nil {
+		t.Error(err)
+	} else if !bytes.Equal(body, fileBytes(testImageFile)) {
+		t.Error(errors.New("downloaded file do not match the original"))
+	}
+}
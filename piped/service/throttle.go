@@ -0,0 +1,35 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/hyperboloide/pipe"
+)
+
+// Throttle is a step that limits the throughput of a writer or a reader.
+type Throttle struct {
+	filterStep
+	// Average number of bytes per second.
+	BytesPerSec int `json:"throttle"`
+	// Maximum number of bytes at once, defaults to BytesPerSec.
+	Burst int `json:"burst"`
+
+	limiter *pipe.Limiter
+}
+
+// Start the Throttle.
+func (t *Throttle) Start() error {
+	if t.BytesPerSec <= 0 {
+		return errors.New("throttle should be a positive number of bytes per second")
+	}
+	t.limiter = pipe.NewLimiter(t.BytesPerSec, t.Burst)
+	t.filter = t.limiter.Filter()
+	return nil
+}
+
+// FilterContext returns a filter that copies the stream at the rate of
+// the Throttle until ctx is done.
+func (t *Throttle) FilterContext(ctx context.Context) pipe.Filter {
+	return t.limiter.FilterContext(ctx)
+}
@@ -0,0 +1,130 @@
+//
+// ratelimit.go
+//
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of this source code package.
+//
+
+package pipe
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// maxThrottleBuffer is the maximum number of bytes a throttled
+// Filter writes at once.
+const maxThrottleBuffer = 32 * 1024
+
+// Limiter is a token bucket that limits the throughput of one or more
+// Filters. A Limiter can be shared by several concurrent Pipes,
+// in which case they share the bandwidth.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter creates a Limiter that allows bytesPerSec on average
+// and up to burst bytes at once. If burst is not set it defaults to
+// bytesPerSec. If bytesPerSec is not positive the Limiter is unlimited.
+func NewLimiter(bytesPerSec, burst int) *Limiter {
+	if bytesPerSec <= 0 {
+		return &Limiter{}
+	}
+	if burst <= 0 {
+		burst = bytesPerSec
+	}
+	return &Limiter{
+		rate:   float64(bytesPerSec),
+		burst:  burst,
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until n bytes can be sent or ctx is done. The bytes are
+// reserved in advance, but the debt of the bucket never exceeds burst:
+// when it is reached the callers wait for the bucket to refill first.
+func (l *Limiter) wait(ctx context.Context, n int) error {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > float64(l.burst) {
+			l.tokens = float64(l.burst)
+		}
+		l.last = now
+
+		reserved := l.tokens-float64(n) >= -float64(l.burst)
+		var delay time.Duration
+		if reserved {
+			l.tokens -= float64(n)
+			if l.tokens < 0 {
+				delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+			}
+		} else {
+			delay = time.Duration((float64(n-l.burst) - l.tokens) / l.rate * float64(time.Second))
+		}
+		l.mu.Unlock()
+
+		if delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+		}
+		if reserved {
+			return nil
+		}
+	}
+}
+
+// Filter returns a Filter that copies the stream at the rate of the Limiter.
+func (l *Limiter) Filter() Filter {
+	return l.FilterContext(context.Background())
+}
+
+// FilterContext is like Filter but stops waiting when ctx is done
+// and returns the context error.
+func (l *Limiter) FilterContext(ctx context.Context) Filter {
+	return func(r io.Reader, w io.Writer) error {
+		if l.rate == 0 {
+			_, err := io.Copy(w, r)
+			return err
+		}
+		size := l.burst
+		if size > maxThrottleBuffer {
+			size = maxThrottleBuffer
+		}
+		buf := make([]byte, size)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				if werr := l.wait(ctx, n); werr != nil {
+					return werr
+				} else if _, werr := w.Write(buf[:n]); werr != nil {
+					return werr
+				}
+			}
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// RateLimit returns a Filter that limits the throughput of the stream to
+// bytesPerSec on average with bursts of up to burst bytes.
+func RateLimit(bytesPerSec, burst int) Filter {
+	return NewLimiter(bytesPerSec, burst).Filter()
+}
@@ -1,27 +1,43 @@
 package file
 
 import (
-	"errors"
+	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/hyperboloide/pipe/rw"
 )
 
-// File defines a Directory to save files.
+// File defines a Directory to save files. The ids are checked with
+// the IDPolicy and cannot access files outside of Dir.
 type File struct {
 	rw.Prefixed
+	rw.IDPolicy
 	// Root dir
 	Dir string `json:"dir"`
 	// Allow the creation of sub directories
 	AllowSub bool `json:"allow_sub"`
 	// Remove Empy directories on Delete.
 	RemoveEmpty bool `json:"remove_empty"`
+	// Store the rw.WriteOptions in sidecar files, see MetaPrefix.
+	Metadata bool `json:"metadata"`
 }
 
-// Start the File. Creates a tempdir is File.Dir == "".
+// TempPrefix is the prefix of the temporary files of the writers.
+// Files with this prefix are removed by Start.
+const TempPrefix = ".pipe-tmp-"
+
+// MetaPrefix is the prefix of the sidecar files that store the
+// rw.WriteOptions of a file, in JSON, in the same directory.
+const MetaPrefix = ".pipe-meta-"
+
+// Start the File. Creates a tempdir is File.Dir == "" and removes
+// the temporary files left by interrupted writers.
 func (s *File) Start() error {
 
 	if s.Dir == "" {
@@ -34,35 +50,88 @@ func (s *File) Start() error {
 	if err := os.MkdirAll(s.Dir, 0700); err != nil {
 		return err
 	}
-	return nil
+	return s.removeTempFiles()
 }
 
-// NewWriter update or create a file.
+// NewWriter update or create a file. The data is written to a temporary
+// file in the same directory that replaces the file on Close, the file
+// is left untouched if the writer is aborted.
 func (s *File) NewWriter(id string) (io.WriteCloser, error) {
-	const mod = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
-	name := s.Prefixed.Name(id)
+	return s.NewWriterWithOptions(id, nil)
+}
 
-	if filepath.Dir(name) == "." {
-		return os.OpenFile(s.join(name), mod, 0600)
-	} else if !s.AllowSub {
-		return nil, errors.New("sub directories not allowed")
+// NewWriterWithOptions is like NewWriter and stores opts in a sidecar
+// file on Close if Metadata is true. The sidecar file of a previous
+// version is removed if opts is nil.
+func (s *File) NewWriterWithOptions(id string, opts *rw.WriteOptions) (io.WriteCloser, error) {
+	name, err := s.name(id)
+	if err != nil {
+		return nil, err
+	}
+	var meta []byte
+	var metaPath string
+	if s.Metadata {
+		metaPath = s.metaPath(name)
 	}
-	if err := os.MkdirAll(s.join(filepath.Dir(name)), 0700); err != nil {
+	if opts != nil && s.Metadata {
+		if meta, err = json.Marshal(opts); err != nil {
+			return nil, err
+		}
+	}
+
+	if dir := filepath.Dir(name); dir != "." {
+		if err := os.MkdirAll(s.join(dir), 0700); err != nil {
+			return nil, err
+		}
+	}
+	f, err := ioutil.TempFile(s.join(filepath.Dir(name)), TempPrefix)
+	if err != nil {
 		return nil, err
 	}
-	return os.OpenFile(s.join(name), mod, 0600)
+	return &atomicWriter{File: f, path: s.join(name), meta: meta, metaPath: metaPath}, nil
 }
 
 // NewReader read a file.
 func (s *File) NewReader(id string) (io.ReadCloser, error) {
-	return os.OpenFile(s.join(s.Prefixed.Name(id)), os.O_RDONLY, 0400)
+	name, err := s.name(id)
+	if err != nil {
+		return nil, err
+	}
+	return os.OpenFile(s.join(name), os.O_RDONLY, 0400)
+}
+
+// NewRangeReader read a part of a file.
+func (s *File) NewRangeReader(id string, offset, length int64) (io.ReadCloser, error) {
+	r, err := s.NewReader(id)
+	if err != nil {
+		return nil, err
+	}
+	f := r.(*os.File)
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	} else if length < 0 {
+		return f, nil
+	}
+	return &limitedReadCloser{io.LimitReader(f, length), f}, nil
+}
+
+// limitedReadCloser reads from a limited reader and closes the file.
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
 }
 
 // Delete a file
 func (s *File) Delete(id string) error {
-	name := s.Prefixed.Name(id)
+	name, err := s.name(id)
+	if err != nil {
+		return err
+	}
 	if err := os.Remove(s.join(name)); err != nil {
 		return err
+	} else if err := os.Remove(s.metaPath(name)); err != nil && !os.IsNotExist(err) {
+		return err
 	}
 	if s.RemoveEmpty && filepath.Dir(name) != "." {
 		return s.removeIfEmpty(filepath.Dir(name))
@@ -70,6 +139,129 @@ func (s *File) Delete(id string) error {
 	return nil
 }
 
+// Stat returns the information of a file and the options stored with it.
+// The ETag is derived from the modification time and the size.
+func (s *File) Stat(id string) (*rw.ObjectInfo, error) {
+	name, err := s.name(id)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(s.join(name))
+	if err != nil {
+		return nil, err
+	} else if !fi.Mode().IsRegular() {
+		return nil, &os.PathError{Op: "stat", Path: s.join(name), Err: rw.ErrNotExist}
+	}
+	info := &rw.ObjectInfo{
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+		ETag:    fmt.Sprintf(`"%x-%x"`, fi.ModTime().UnixNano(), fi.Size()),
+	}
+
+	opts := rw.WriteOptions{}
+	if meta, err := ioutil.ReadFile(s.metaPath(name)); os.IsNotExist(err) {
+		return info, nil
+	} else if err != nil {
+		return nil, err
+	} else if err := json.Unmarshal(meta, &opts); err != nil {
+		return nil, err
+	}
+	info.ContentType, info.Metadata = opts.ContentType, opts.Metadata
+	return info, nil
+}
+
+// metaPath returns the path of the sidecar file of the file name.
+func (s *File) metaPath(name string) string {
+	return s.join(filepath.Join(filepath.Dir(name), MetaPrefix+filepath.Base(name)))
+}
+
+// name returns the name of the file with id relative to Dir. It returns
+// an *rw.InvalidIDError if id is rejected by the IDPolicy or if the file
+// is not in Dir.
+func (s *File) name(id string) (string, error) {
+	if err := s.IDPolicy.Check(id); err != nil {
+		return "", err
+	}
+	name := filepath.Clean(s.Prefixed.Name(id))
+	if filepath.Dir(name) != "." && !s.AllowSub {
+		return "", &rw.InvalidIDError{ID: id, Reason: "sub directories not allowed"}
+	}
+	rel, err := filepath.Rel(s.Dir, s.join(name))
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &rw.InvalidIDError{ID: id, Reason: "outside of the directory"}
+	}
+	for _, segment := range strings.Split(filepath.ToSlash(rel), "/") {
+		if strings.HasPrefix(segment, TempPrefix) || strings.HasPrefix(segment, MetaPrefix) {
+			return "", &rw.InvalidIDError{ID: id, Reason: "reserved name"}
+		}
+	}
+	return rel, nil
+}
+
+// List the ids of the files starting with prefix, the cursor is the name
+// of the last file of the previous page. Temporary and sidecar files and
+// files with an id rejected by the IDPolicy are not listed.
+// The files are walked in the order of their names until the page is full,
+// the directories that sort before the cursor are not read.
+func (s *File) List(prefix, cursor string, limit int) (*rw.Page, error) {
+	start := s.Prefixed.Prefix + prefix
+	limit = rw.ListLimit(limit)
+	var names []string
+
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		infos, err := ioutil.ReadDir(s.join(filepath.FromSlash(dir)))
+		if err != nil {
+			return err
+		}
+		// a directory sorts like its content: "a/b" is after "a.b".
+		key := func(info os.FileInfo) string {
+			if info.IsDir() {
+				return info.Name() + "/"
+			}
+			return info.Name()
+		}
+		sort.Slice(infos, func(i, j int) bool { return key(infos[i]) < key(infos[j]) })
+
+		for _, info := range infos {
+			if len(names) > limit {
+				return nil
+			}
+			name := dir + key(info)
+			if info.IsDir() {
+				// skip the directories that cannot contain the prefix
+				// and those that sort before the cursor.
+				if !s.AllowSub || !(strings.HasPrefix(start, name) || strings.HasPrefix(name, start)) ||
+					(name <= cursor && !strings.HasPrefix(cursor, name)) {
+					continue
+				} else if err := walk(name); err != nil {
+					return err
+				}
+			} else if info.Mode().IsRegular() && !strings.HasPrefix(info.Name(), TempPrefix) &&
+				!strings.HasPrefix(info.Name(), MetaPrefix) && name > cursor && strings.HasPrefix(name, start) {
+				if id, ok := s.Prefixed.ID(name); ok && s.IDPolicy.Check(id) == nil {
+					names = append(names, name)
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(""); err != nil {
+		return nil, err
+	}
+
+	page := &rw.Page{IDs: []string{}}
+	for i, name := range names {
+		if i == limit {
+			page.Next = names[i-1]
+			break
+		}
+		id, _ := s.Prefixed.ID(name)
+		page.IDs = append(page.IDs, id)
+	}
+	return page, nil
+}
+
 func (s *File) join(path string) string {
 	return filepath.Join(s.Dir, path)
 }
@@ -98,3 +290,79 @@ func (s *File) removeIfEmpty(dir string) error {
 	}
 	return s.removeIfEmpty(filepath.Dir(dir))
 }
+
+// removeTempFiles removes the temporary files in Dir and its sub
+// directories if AllowSub is true.
+func (s *File) removeTempFiles() error {
+	return filepath.Walk(s.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		} else if info.IsDir() && path != s.Dir && !s.AllowSub {
+			return filepath.SkipDir
+		} else if !info.IsDir() && strings.HasPrefix(info.Name(), TempPrefix) {
+			return os.Remove(path)
+		}
+		return nil
+	})
+}
+
+// atomicWriter writes to a temporary file and renames it to path on Close.
+// meta is then written to the sidecar file at metaPath, or the sidecar
+// file is removed if meta is nil. There is no sidecar if metaPath is "".
+type atomicWriter struct {
+	*os.File
+	path     string
+	meta     []byte
+	metaPath string
+}
+
+// Close syncs the temporary file and renames it, the temporary file is
+// removed if it fails.
+func (a *atomicWriter) Close() error {
+	if err := a.File.Sync(); err != nil {
+		a.Abort()
+		return err
+	} else if err := a.File.Close(); err != nil {
+		os.Remove(a.File.Name())
+		return err
+	} else if err := os.Rename(a.File.Name(), a.path); err != nil {
+		os.Remove(a.File.Name())
+		return err
+	} else if err := a.writeMeta(); err != nil {
+		return err
+	}
+	// persist the rename, not supported on all platforms.
+	if dir, err := os.Open(filepath.Dir(a.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// writeMeta writes or removes the sidecar file.
+func (a *atomicWriter) writeMeta() error {
+	if a.metaPath == "" {
+		return nil
+	} else if a.meta == nil {
+		if err := os.Remove(a.metaPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	f, err := ioutil.TempFile(filepath.Dir(a.metaPath), TempPrefix)
+	if err != nil {
+		return err
+	}
+	w := &atomicWriter{File: f, path: a.metaPath}
+	if _, err := w.Write(a.meta); err != nil {
+		w.Abort()
+		return err
+	}
+	return w.Close()
+}
+
+// Abort closes and removes the temporary file.
+func (a *atomicWriter) Abort() error {
+	a.File.Close()
+	return os.Remove(a.File.Name())
+}
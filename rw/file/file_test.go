@@ -1,9 +1,18 @@
 package file_test
 
 import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hyperboloide/pipe"
+	"github.com/hyperboloide/pipe/rw"
 	"github.com/hyperboloide/pipe/rw/file"
 	"github.com/hyperboloide/pipe/tests"
-	"testing"
 )
 
 func TestFile(t *testing.T) {
@@ -18,3 +27,183 @@ func TestFile(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestAtomicWrite(t *testing.T) {
+	f := &file.File{AllowSub: true}
+	if err := f.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(f.Dir)
+
+	write := func(content string, abort bool) {
+		w, err := f.NewWriter("sub/test_file")
+		if err != nil {
+			t.Fatal(err)
+		} else if _, err := io.WriteString(w, content); err != nil {
+			t.Fatal(err)
+		} else if abort {
+			err = w.(pipe.Aborter).Abort()
+		} else {
+			err = w.Close()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("good", false)
+	write("partial", true)
+	if b, err := ioutil.ReadFile(filepath.Join(f.Dir, "sub/test_file")); err != nil {
+		t.Fatal(err)
+	} else if string(b) != "good" {
+		t.Errorf("aborted write should keep the file, got %q", string(b))
+	}
+	if files, err := ioutil.ReadDir(filepath.Join(f.Dir, "sub")); err != nil {
+		t.Fatal(err)
+	} else if len(files) != 1 {
+		t.Errorf("aborted write should remove the temporary file, found %d files", len(files))
+	}
+
+	// orphaned temp files are removed at Start
+	orphan := filepath.Join(f.Dir, "sub", file.TempPrefix+"123")
+	if err := ioutil.WriteFile(orphan, []byte("orphan"), 0600); err != nil {
+		t.Fatal(err)
+	} else if err := f.Start(); err != nil {
+		t.Fatal(err)
+	} else if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Error("orphaned temporary file should be removed")
+	}
+}
+
+func TestInvalidIDs(t *testing.T) {
+	f := &file.File{}
+	if err := f.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(f.Dir)
+	sub := &file.File{Dir: f.Dir, AllowSub: true, IDPolicy: rw.IDPolicy{MaxLength: 16, AllowDotfiles: true}}
+
+	cases := []struct {
+		f  *file.File
+		id string
+	}{
+		{f, ""},
+		{f, "../etc/passwd"},
+		{f, ".hidden"},
+		{f, "sub/test_file"},
+		{f, "a b"},
+		{f, strings.Repeat("a", 256)},
+		{sub, "a/../../etc/passwd"},
+		{sub, "/etc/passwd"},
+		{sub, "a//b"},
+		{sub, "a/.."},
+		{sub, "a\\b"},
+		{sub, strings.Repeat("a", 17)},
+	}
+	for _, c := range cases {
+		var ie *rw.InvalidIDError
+		if _, err := c.f.NewWriter(c.id); !errors.As(err, &ie) {
+			t.Errorf("writer with id %q should fail with an InvalidIDError, got %v", c.id, err)
+		}
+		if _, err := c.f.NewReader(c.id); !errors.As(err, &ie) {
+			t.Errorf("reader with id %q should fail with an InvalidIDError, got %v", c.id, err)
+		}
+		if err := c.f.Delete(c.id); !errors.As(err, &ie) {
+			t.Errorf("delete with id %q should fail with an InvalidIDError, got %v", c.id, err)
+		}
+	}
+
+	// dotfiles can be allowed
+	if w, err := sub.NewWriter("a/.b"); err != nil {
+		t.Error(err)
+	} else if err := w.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestList(t *testing.T) {
+	f := &file.File{AllowSub: true, Prefixed: rw.Prefixed{Prefix: "p_", Suffix: ".txt"}}
+	if err := tests.TestLister(f, "some/dir/"); err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(f.Dir)
+
+	// other files are not listed
+	for _, name := range []string{"other.txt", "p_a.bin", "p_" + file.TempPrefix + "1.txt", "p_.hidden.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(f.Dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{"a", "a.b", "sub/b", "sub.c", "sub/d/e"} {
+		if w, err := f.NewWriter(id); err != nil {
+			t.Fatal(err)
+		} else if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the ids are in the order of the names of the files:
+	// "p_a.b.txt" is before "p_a.txt".
+	if page, err := f.List("", "", 0); err != nil {
+		t.Error(err)
+	} else if strings.Join(page.IDs, ",") != "a.b,a,sub.c,sub/b,sub/d/e" || page.Next != "" {
+		t.Errorf("invalid page: %v", page)
+	}
+
+	// the pages resume from the cursor in the same order.
+	var ids []string
+	for cursor := ""; ; {
+		page, err := f.List("", cursor, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, page.IDs...)
+		if cursor = page.Next; cursor == "" {
+			break
+		}
+	}
+	if strings.Join(ids, ",") != "a.b,a,sub.c,sub/b,sub/d/e" {
+		t.Errorf("invalid pages: %v", ids)
+	}
+}
+
+func TestStat(t *testing.T) {
+	f := &file.File{AllowSub: true, Metadata: true, IDPolicy: rw.IDPolicy{AllowDotfiles: true}}
+	if err := tests.TestStater(f, "sub/test_file"); err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(f.Dir)
+
+	// a new version without options removes the metadata
+	opts := &rw.WriteOptions{ContentType: "text/plain"}
+	for _, o := range []*rw.WriteOptions{opts, nil} {
+		if w, err := f.NewWriterWithOptions("test_file", o); err != nil {
+			t.Fatal(err)
+		} else if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if info, err := f.Stat("test_file"); err != nil {
+		t.Error(err)
+	} else if info.ContentType != "" {
+		t.Error("metadata of the previous version should be removed")
+	}
+	if page, err := f.List("", "", 0); err != nil {
+		t.Error(err)
+	} else if strings.Join(page.IDs, ",") != "test_file" {
+		t.Errorf("sidecar files should not be listed: %v", page.IDs)
+	}
+
+	// sidecar files cannot be accessed
+	var ie *rw.InvalidIDError
+	if _, err := f.NewReader(file.MetaPrefix + "test_file"); !errors.As(err, &ie) {
+		t.Errorf("sidecar file should not be readable, got %v", err)
+	}
+}
+
+func TestRangeReader(t *testing.T) {
+	f := &file.File{}
+	if err := tests.TestRangeReader(f, "test_file"); err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(f.Dir)
+}
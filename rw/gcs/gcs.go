@@ -2,18 +2,20 @@ package gcs
 
 import (
 	"context"
+	"fmt"
 	"io"
 
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 
-	"github.com/hyperboloide/pipe/rw"
+	piperw "github.com/hyperboloide/pipe/rw"
 
 	"cloud.google.com/go/storage"
 )
 
 // GCS defines a Google Cloud Storage connection to a bucket.
 type GCS struct {
-	rw.Prefixed
+	piperw.Prefixed
 
 	Bucket            string `json:"bucket"`
 	ServiceAccountKey string `json:"key"`
@@ -47,6 +49,19 @@ func (rw *GCS) NewWriter(id string) (io.WriteCloser, error) {
 	return obj.NewWriter(ctx), nil
 }
 
+// NewWriterWithOptions returns a Google Cloud Storage Writer that
+// stores the content type and the user metadata of opts with the object.
+func (rw *GCS) NewWriterWithOptions(id string, opts *piperw.WriteOptions) (io.WriteCloser, error) {
+	ctx := context.Background()
+	obj := rw.bucket.Object(rw.Prefixed.Name(id))
+	w := obj.NewWriter(ctx)
+	if opts != nil {
+		w.ContentType = opts.ContentType
+		w.Metadata = opts.Metadata
+	}
+	return w, nil
+}
+
 // NewReader returns a Google Cloud Storage Reader
 func (rw *GCS) NewReader(id string) (io.ReadCloser, error) {
 	ctx := context.Background()
@@ -54,9 +69,68 @@ func (rw *GCS) NewReader(id string) (io.ReadCloser, error) {
 	return obj.NewReader(ctx)
 }
 
+// NewRangeReader returns a Google Cloud Storage Reader of a part
+// of an object.
+func (rw *GCS) NewRangeReader(id string, offset, length int64) (io.ReadCloser, error) {
+	ctx := context.Background()
+	obj := rw.bucket.Object(rw.Prefixed.Name(id))
+	return obj.NewRangeReader(ctx, offset, length)
+}
+
 // Delete an Google Cloud Storage object
 func (rw *GCS) Delete(id string) error {
 	ctx := context.Background()
 	obj := rw.bucket.Object(rw.Prefixed.Name(id))
 	return obj.Delete(ctx)
 }
+
+// List the ids of the Google Cloud Storage objects starting with prefix,
+// the cursor is the name of the last object of the previous page.
+func (rw *GCS) List(prefix, cursor string, limit int) (*piperw.Page, error) {
+	ctx := context.Background()
+	limit = piperw.ListLimit(limit)
+	it := rw.bucket.Objects(ctx, &storage.Query{
+		Prefix:      rw.Prefixed.Prefix + prefix,
+		StartOffset: cursor,
+	})
+
+	page := &piperw.Page{IDs: []string{}}
+	var last string
+	for n := 0; ; {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return page, nil
+		} else if err != nil {
+			return nil, err
+		} else if attrs.Name == cursor {
+			// StartOffset is inclusive.
+			continue
+		} else if n == limit {
+			page.Next = last
+			return page, nil
+		}
+		if id, ok := rw.Prefixed.ID(attrs.Name); ok {
+			page.IDs = append(page.IDs, id)
+		}
+		last = attrs.Name
+		n++
+	}
+}
+
+// Stat returns the attributes of a Google Cloud Storage object.
+func (rw *GCS) Stat(id string) (*piperw.ObjectInfo, error) {
+	ctx := context.Background()
+	attrs, err := rw.bucket.Object(rw.Prefixed.Name(id)).Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, fmt.Errorf("gcs object %s: %w", rw.Prefixed.Name(id), piperw.ErrNotExist)
+	} else if err != nil {
+		return nil, err
+	}
+	return &piperw.ObjectInfo{
+		Size:        attrs.Size,
+		ModTime:     attrs.Updated,
+		ETag:        attrs.Etag,
+		ContentType: attrs.ContentType,
+		Metadata:    attrs.Metadata,
+	}, nil
+}
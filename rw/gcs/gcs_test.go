@@ -30,4 +30,15 @@ func TestGCS(t *testing.T) {
 		t.Error(err)
 	}
 
+	if err := tests.TestLister(s, "pipe_test_list/"); err != nil {
+		t.Error(err)
+	}
+
+	if err := tests.TestStater(s, "pipe_test_stat"); err != nil {
+		t.Error(err)
+	}
+
+	if err := tests.TestRangeReader(s, "pipe_test_range"); err != nil {
+		t.Error(err)
+	}
 }
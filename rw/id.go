@@ -0,0 +1,70 @@
+package rw
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultIDCharset is the charset of the ids if IDPolicy.Charset is empty.
+const DefaultIDCharset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_."
+
+// DefaultMaxIDLength is the maximum length of the ids if
+// IDPolicy.MaxLength is 0.
+const DefaultMaxIDLength = 255
+
+// InvalidIDError is returned when an id is rejected by an IDPolicy or
+// would access data outside of the storage.
+type InvalidIDError struct {
+	ID     string
+	Reason string
+}
+
+func (e *InvalidIDError) Error() string {
+	return fmt.Sprintf("invalid id %q: %s", e.ID, e.Reason)
+}
+
+// IDPolicy defines the ids accepted by a storage. An id is a list of
+// segments separated by "/", each segment should only contain characters
+// of Charset and cannot start with a "." unless AllowDotfiles is true.
+// "." and ".." segments are always rejected.
+type IDPolicy struct {
+	// Characters allowed in ids, defaults to DefaultIDCharset.
+	Charset string `json:"id_charset"`
+	// Maximum length of the ids, defaults to DefaultMaxIDLength.
+	MaxLength int `json:"id_max_length"`
+	// Allow the segments of the ids to start with a "."
+	AllowDotfiles bool `json:"allow_dotfiles"`
+}
+
+// Check returns an *InvalidIDError if id is not valid.
+func (p *IDPolicy) Check(id string) error {
+	charset, maxLength := p.Charset, p.MaxLength
+	if charset == "" {
+		charset = DefaultIDCharset
+	}
+	if maxLength == 0 {
+		maxLength = DefaultMaxIDLength
+	}
+
+	if id == "" {
+		return &InvalidIDError{id, "empty id"}
+	} else if len(id) > maxLength {
+		return &InvalidIDError{id, fmt.Sprintf("longer than %d bytes", maxLength)}
+	}
+	for _, segment := range strings.Split(id, "/") {
+		switch {
+		case segment == "":
+			return &InvalidIDError{id, "empty segment"}
+		case segment == "." || segment == "..":
+			return &InvalidIDError{id, "relative segment"}
+		case strings.HasPrefix(segment, ".") && !p.AllowDotfiles:
+			return &InvalidIDError{id, "dotfiles not allowed"}
+		}
+		for _, c := range segment {
+			if !strings.ContainsRune(charset, c) {
+				return &InvalidIDError{id, fmt.Sprintf("character %q not allowed", c)}
+			}
+		}
+	}
+	return nil
+}
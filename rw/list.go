@@ -0,0 +1,36 @@
+package rw
+
+// DefaultListLimit is the number of ids of a Page if the limit is 0.
+const DefaultListLimit = 100
+
+// MaxListLimit is the maximum number of ids of a Page.
+const MaxListLimit = 1000
+
+// Page is a page of ids returned by a Lister.
+type Page struct {
+	IDs []string `json:"ids"`
+	// Cursor of the next page, empty on the last page.
+	Next string `json:"next,omitempty"`
+}
+
+// Lister is a base interface to list the stored ids. List returns the
+// ids starting with prefix in the lexical order of their stored names,
+// at most limit ids per page. With the Suffix of a Prefixed it can differ
+// from the order of the ids: "a.b.txt" is before "a.txt".
+// cursor is empty for the first page and Page.Next for the next ones,
+// it is opaque and specific to the Lister.
+type Lister interface {
+	Base
+	List(prefix, cursor string, limit int) (*Page, error)
+}
+
+// ListLimit returns the limit of a Page, DefaultListLimit if limit is 0
+// and at most MaxListLimit.
+func ListLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultListLimit
+	} else if limit > MaxListLimit {
+		return MaxListLimit
+	}
+	return limit
+}
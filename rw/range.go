@@ -0,0 +1,10 @@
+package rw
+
+import "io"
+
+// RangeReader is a base interface to read a part of an object, from
+// offset and at most length bytes, or until the end if length is -1.
+type RangeReader interface {
+	Base
+	NewRangeReader(id string, offset, length int64) (io.ReadCloser, error)
+}
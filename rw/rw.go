@@ -2,6 +2,7 @@ package rw
 
 import (
 	"io"
+	"strings"
 )
 
 // Base is an interface that defines a start function, used for setup.
@@ -67,3 +68,14 @@ type Prefixed struct {
 func (p *Prefixed) Name(id string) string {
 	return p.Prefix + id + p.Suffix
 }
+
+// ID returns the id of the object with name, and false if name does not
+// have the prefix and suffix.
+func (p *Prefixed) ID(name string) (string, bool) {
+	if len(name) < len(p.Prefix)+len(p.Suffix) ||
+		!strings.HasPrefix(name, p.Prefix) ||
+		!strings.HasSuffix(name, p.Suffix) {
+		return "", false
+	}
+	return name[len(p.Prefix) : len(name)-len(p.Suffix)], true
+}
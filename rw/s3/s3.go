@@ -1,8 +1,15 @@
 package s3
 
 import (
+	"encoding/xml"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/hyperboloide/pipe/rw"
 	"github.com/rlmcpherson/s3gof3r"
@@ -25,6 +32,12 @@ type S3 struct {
 
 	// Bucket name
 	Bucket string `json:"bucket"`
+
+	// Report a 403 on a HEAD as a missing object. Without the
+	// s3:ListBucket permission S3 replies 403 to a HEAD on a missing
+	// object, but also when the object cannot be read.
+	ForbiddenAsNotExist bool `json:"forbidden_as_not_exist"`
+
 	bucket *s3gof3r.Bucket
 }
 
@@ -55,18 +68,173 @@ func (s *S3) Start() error {
 	return nil
 }
 
+// MetadataHeaderPrefix is the prefix of the headers of the S3 user metadata.
+const MetadataHeaderPrefix = "X-Amz-Meta-"
+
 // NewWriter returns a new S3 Writer
 func (s *S3) NewWriter(id string) (io.WriteCloser, error) {
 	return s.bucket.PutWriter(s.Prefixed.Name(id), nil, nil)
 }
 
+// NewWriterWithOptions returns a new S3 Writer that stores the content
+// type and the user metadata of opts with the object.
+func (s *S3) NewWriterWithOptions(id string, opts *rw.WriteOptions) (io.WriteCloser, error) {
+	if opts == nil {
+		return s.NewWriter(id)
+	}
+	header := http.Header{}
+	if opts.ContentType != "" {
+		header.Set("Content-Type", opts.ContentType)
+	}
+	for k, v := range opts.Metadata {
+		header.Set(MetadataHeaderPrefix+k, v)
+	}
+	return s.bucket.PutWriter(s.Prefixed.Name(id), header, nil)
+}
+
+// Stat returns the information of an S3 object with a HEAD request.
+// The keys of the user metadata are in lower case. A 404 is reported as
+// rw.ErrNotExist and a 403 as rw.ErrPermission, or as rw.ErrNotExist with
+// ForbiddenAsNotExist.
+func (s *S3) Stat(id string) (*rw.ObjectInfo, error) {
+	u := s.bucket.Url(s.Prefixed.Name(id), s.bucket.Config)
+	req, err := http.NewRequest("HEAD", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	s.bucket.Sign(req)
+	resp, err := s.bucket.Config.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound ||
+		(resp.StatusCode == http.StatusForbidden && s.ForbiddenAsNotExist) {
+		return nil, fmt.Errorf("s3 object %s: %w", s.Prefixed.Name(id), rw.ErrNotExist)
+	} else if resp.StatusCode == http.StatusForbidden {
+		return nil, fmt.Errorf("s3 object %s: %w", s.Prefixed.Name(id), rw.ErrPermission)
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("s3 stat failed with status %d", resp.StatusCode)
+	}
+
+	info := &rw.ObjectInfo{
+		Size:        resp.ContentLength,
+		ETag:        resp.Header.Get("ETag"),
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+	if info.ModTime, err = http.ParseTime(resp.Header.Get("Last-Modified")); err != nil {
+		return nil, err
+	}
+	for k := range resp.Header {
+		if strings.HasPrefix(k, MetadataHeaderPrefix) {
+			if info.Metadata == nil {
+				info.Metadata = map[string]string{}
+			}
+			info.Metadata[strings.ToLower(k[len(MetadataHeaderPrefix):])] = resp.Header.Get(k)
+		}
+	}
+	return info, nil
+}
+
 // NewReader returns a new S3 Reader
 func (s *S3) NewReader(id string) (io.ReadCloser, error) {
 	r, _, err := s.bucket.GetReader(s.Prefixed.Name(id), nil)
 	return r, err
 }
 
+// NewRangeReader returns a new S3 Reader of a part of an object with
+// a range request.
+func (s *S3) NewRangeReader(id string, offset, length int64) (io.ReadCloser, error) {
+	u := s.bucket.Url(s.Prefixed.Name(id), s.bucket.Config)
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if length < 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	} else if length == 0 {
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	} else {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	}
+	s.bucket.Sign(req)
+	resp, err := s.bucket.Config.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusOK:
+		// the range is ignored for some objects, the whole object is sent.
+		if offset == 0 && length < 0 {
+			return resp.Body, nil
+		} else if offset == 0 {
+			return struct {
+				io.Reader
+				io.Closer
+			}{io.LimitReader(resp.Body, length), resp.Body}, nil
+		}
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 object %s: %w", s.Prefixed.Name(id), rw.ErrNotExist)
+	}
+	resp.Body.Close()
+	return nil, fmt.Errorf("s3 range read failed with status %d", resp.StatusCode)
+}
+
 // Delete an S3 object
 func (s *S3) Delete(id string) error {
 	return s.bucket.Delete(s.Prefixed.Name(id))
 }
+
+// listBucketResult is the response of the S3 ListObjectsV2 API.
+type listBucketResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated bool
+}
+
+// List the ids of the S3 objects starting with prefix, the cursor is
+// the key of the last object of the previous page.
+func (s *S3) List(prefix, cursor string, limit int) (*rw.Page, error) {
+	u := s.bucket.Url("/", s.bucket.Config)
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("prefix", s.Prefixed.Prefix+prefix)
+	query.Set("max-keys", strconv.Itoa(rw.ListLimit(limit)))
+	if cursor != "" {
+		query.Set("start-after", cursor)
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	s.bucket.Sign(req)
+	resp, err := s.bucket.Config.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("s3 list failed with status %d", resp.StatusCode)
+	}
+
+	res := listBucketResult{}
+	if err := xml.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+	page := &rw.Page{IDs: []string{}}
+	for _, c := range res.Contents {
+		if id, ok := s.Prefixed.ID(c.Key); ok {
+			page.IDs = append(page.IDs, id)
+		}
+	}
+	if res.IsTruncated && len(res.Contents) > 0 {
+		page.Next = res.Contents[len(res.Contents)-1].Key
+	}
+	return page, nil
+}
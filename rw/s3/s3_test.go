@@ -36,4 +36,15 @@ func TestS3(t *testing.T) {
 		t.Error(err)
 	}
 
+	if err := tests.TestLister(s, "s3_test_list/"); err != nil {
+		t.Error(err)
+	}
+
+	if err := tests.TestStater(s, "s3_test_stat"); err != nil {
+		t.Error(err)
+	}
+
+	if err := tests.TestRangeReader(s, "s3_test_range"); err != nil {
+		t.Error(err)
+	}
 }
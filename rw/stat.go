@@ -0,0 +1,45 @@
+package rw
+
+import (
+	"io"
+	"os"
+	"time"
+)
+
+// ErrNotExist is returned (or wrapped) by a Stater when the object does
+// not exist. It is os.ErrNotExist so that the errors of the file system
+// match too.
+var ErrNotExist = os.ErrNotExist
+
+// ErrPermission is returned (or wrapped) by a Stater when the access to
+// the object is denied. It is os.ErrPermission.
+var ErrPermission = os.ErrPermission
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Size        int64             `json:"size"`
+	ModTime     time.Time         `json:"mtime"`
+	ETag        string            `json:"etag,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// Stater is a base interface to get the information of an object
+// without reading it.
+type Stater interface {
+	Base
+	Stat(string) (*ObjectInfo, error)
+}
+
+// WriteOptions are stored with an object by a MetadataWriter.
+type WriteOptions struct {
+	ContentType string            `json:"content_type,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// MetadataWriter is a Writer that can store a content type and user
+// metadata with the objects.
+type MetadataWriter interface {
+	Writer
+	NewWriterWithOptions(string, *WriteOptions) (io.WriteCloser, error)
+}
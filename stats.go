@@ -0,0 +1,171 @@
+//
+// stats.go
+//
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of this source code package.
+//
+
+package pipe
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultProgressInterval is the interval between two calls of the
+// progress function if Pipe.ProgressInterval is not set.
+const DefaultProgressInterval = time.Second
+
+// stage counts the bytes produced by a stage of the Pipe.
+type stage struct {
+	// accessed atomically, keep first for alignment.
+	bytes int64
+	name  string
+}
+
+func (s *stage) add(n int) {
+	atomic.AddInt64(&s.bytes, int64(n))
+}
+
+func (s *stage) total() int64 {
+	return atomic.LoadInt64(&s.bytes)
+}
+
+// countWriter counts the bytes written to w in a stage.
+type countWriter struct {
+	w io.Writer
+	s *stage
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.s.add(n)
+	return n, err
+}
+
+// countReader counts the bytes read from r in a stage.
+type countReader struct {
+	r io.Reader
+	s *stage
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.s.add(n)
+	return n, err
+}
+
+// StageStats are the counters of a stage.
+type StageStats struct {
+	Index int    `json:"index"`
+	Name  string `json:"name,omitempty"`
+	// Number of bytes read by the stage.
+	BytesIn int64 `json:"bytes_in"`
+	// Number of bytes written by the stage.
+	BytesOut int64 `json:"bytes_out"`
+}
+
+// Ratio returns BytesOut / BytesIn, for example the compression ratio
+// of an encoder.
+func (s StageStats) Ratio() float64 {
+	if s.BytesIn == 0 {
+		return 0
+	}
+	return float64(s.BytesOut) / float64(s.BytesIn)
+}
+
+// Stats are the counters of a Pipe at a given time.
+type Stats struct {
+	// Branch of the Pipe, see StageError.
+	Branch string `json:"branch,omitempty"`
+	// Time since the creation of the Pipe.
+	Elapsed time.Duration `json:"elapsed"`
+	// Number of bytes read at the origin of the Pipe.
+	BytesIn int64 `json:"bytes_in"`
+	// Number of bytes written at the end of the Pipe.
+	BytesOut int64 `json:"bytes_out"`
+	// Stages in order, starting with the reader and ending with the writer.
+	Stages []StageStats `json:"stages"`
+	// Stats of the tees created from the Pipe.
+	Tees []Stats `json:"tees,omitempty"`
+}
+
+// Throughput returns the number of bytes read per second
+// at the origin of the Pipe.
+func (s Stats) Throughput() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.BytesIn) / s.Elapsed.Seconds()
+}
+
+// BytesIn returns the number of bytes read at the origin of the Pipe so far.
+// It's safe to call while the Pipe is running.
+func (p *Pipe) BytesIn() int64 {
+	return p.stages[0].total()
+}
+
+// BytesOut returns the number of bytes written at the end of the Pipe so far.
+// It's safe to call while the Pipe is running.
+func (p *Pipe) BytesOut() int64 {
+	return p.writer.total()
+}
+
+// Stats returns the current counters of the Pipe and its tees.
+// It's safe to call while the Pipe is running.
+func (p *Pipe) Stats() Stats {
+	res := Stats{
+		Branch:   p.branch,
+		Elapsed:  time.Since(p.start),
+		BytesIn:  p.BytesIn(),
+		BytesOut: p.BytesOut(),
+	}
+	stages := make([]*stage, 0, len(p.stages)+1)
+	stages = append(stages, p.stages...)
+	stages = append(stages, p.writer)
+
+	var in int64
+	for i, s := range stages {
+		out := s.total()
+		if i == 0 {
+			in = out
+		}
+		res.Stages = append(res.Stages, StageStats{
+			Index:    i,
+			Name:     s.name,
+			BytesIn:  in,
+			BytesOut: out,
+		})
+		in = out
+	}
+	for _, tee := range p.tees {
+		res.Tees = append(res.Tees, tee.Stats())
+	}
+	return res
+}
+
+// OnProgress sets a function called with the Stats of the Pipe every
+// ProgressInterval while Exec is running, and a last time when it returns.
+func (p *Pipe) OnProgress(fn func(Stats)) *Pipe {
+	p.progress = fn
+	return p
+}
+
+// report calls the progress function until done is closed.
+func (p *Pipe) report(done chan struct{}) {
+	interval := p.ProgressInterval
+	if interval <= 0 {
+		interval = DefaultProgressInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.progress(p.Stats())
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,72 @@
+//
+// switch.go
+//
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of this source code package.
+//
+
+package pipe
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// SniffSize is the number of bytes read by Switch to detect
+// the content type of the stream.
+const SniffSize = 512
+
+// Case of a Switch.
+type Case struct {
+	// Media type pattern like "image/jpeg", "image/*" or "*".
+	Pattern string
+	// Filter applied to the stream if the content type matches Pattern.
+	// If nil the stream is copied without changes.
+	Filter Filter
+}
+
+// MatchMediaType returns true if the media type of contentType
+// matches pattern. The pattern can be a media type ("image/png"),
+// a type with any sub type ("image/*") or anything ("*" or "*/*").
+func MatchMediaType(pattern, contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	pattern = strings.ToLower(pattern)
+	switch {
+	case pattern == "*" || pattern == "*/*":
+		return true
+	case strings.HasSuffix(pattern, "/*"):
+		return strings.HasPrefix(mt, strings.TrimSuffix(pattern, "*"))
+	}
+	return mt == pattern
+}
+
+// Switch returns a Filter that detects the content type of the stream from
+// its first bytes with http.DetectContentType and applies the Filter of the
+// first case that matches. If no case matches, the stream is copied
+// without changes.
+func Switch(cases ...Case) Filter {
+	return func(r io.Reader, w io.Writer) error {
+		br := bufio.NewReaderSize(r, SniffSize)
+		head, err := br.Peek(SniffSize)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		contentType := http.DetectContentType(head)
+
+		for _, c := range cases {
+			if MatchMediaType(c.Pattern, contentType) {
+				if c.Filter == nil {
+					break
+				}
+				return c.Filter(br, w)
+			}
+		}
+		return Identity(br, w)
+	}
+}
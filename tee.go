@@ -0,0 +1,243 @@
+//
+// tee.go
+//
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of this source code package.
+//
+
+package pipe
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// TeeBufferSize is the maximum number of bytes queued for a BestEffort tee.
+const TeeBufferSize = 4 << 20
+
+// ErrSlowTee detaches a BestEffort tee that does not read its stream as
+// fast as the Pipe it was created from.
+var ErrSlowTee = errors.New("tee is too slow, its buffer is full")
+
+// TeePolicy defines how the failure of a tee affects the Pipe
+// it was created from.
+type TeePolicy struct {
+	detach   bool
+	quorum   int
+	buffer   int
+	isQuorum bool
+}
+
+var (
+	// Required tees fail the whole Pipe, this is the policy of Tee.
+	Required = TeePolicy{}
+
+	// BestEffort tees are detached when they fail: the error is reported
+	// by Detached and the Pipe continues without them. They do not slow
+	// down the Pipe: the stream is queued in a buffer of TeeBufferSize
+	// bytes and the tee fails with ErrSlowTee when it is full.
+	BestEffort = TeePolicy{detach: true, buffer: TeeBufferSize}
+)
+
+// Quorum returns a policy where tees are detached when they fail, as long
+// as at least n of the tees created with a Quorum policy from the same Pipe
+// can still succeed. Otherwise the whole Pipe fails. Like BestEffort tees
+// they are buffered and fail with ErrSlowTee when they are too slow.
+// Exec fails if n is not positive or if there are less than n tees.
+func Quorum(n int) TeePolicy {
+	return TeePolicy{detach: true, quorum: n, buffer: TeeBufferSize, isQuorum: true}
+}
+
+// quorum counts the tees of a Pipe with a Quorum policy.
+type quorum struct {
+	sync.Mutex
+	total  int
+	failed int
+	// the largest quorum of the tees.
+	required int
+}
+
+// check returns an error if the quorum can never be reached.
+func (q *quorum) check() error {
+	q.Lock()
+	defer q.Unlock()
+	if q.total > 0 && (q.required <= 0 || q.required > q.total) {
+		return fmt.Errorf("invalid quorum of %d with %d tees", q.required, q.total)
+	}
+	return nil
+}
+
+// bufferedWriter writes to a tee in the background so that a slow tee
+// does not block the Pipe. At most size bytes are queued, when the queue
+// is full the tee group fails with err.
+type bufferedWriter struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []byte
+	spare  []byte
+	size   int
+	closed bool
+	failed bool
+
+	w   *io.PipeWriter
+	g   *group
+	err error
+}
+
+func newBufferedWriter(w *io.PipeWriter, g *group, size int, err error) *bufferedWriter {
+	b := &bufferedWriter{w: w, g: g, size: size, err: err}
+	b.cond = sync.NewCond(&b.mu)
+	go b.run()
+	return b
+}
+
+func (b *bufferedWriter) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	if b.failed || b.g.isAborted() {
+		b.mu.Unlock()
+		return len(p), nil
+	} else if len(b.queue)+len(p) > b.size {
+		b.failed = true
+		b.queue = nil
+		b.mu.Unlock()
+		b.g.fail(b.err)
+		return len(p), nil
+	}
+	b.queue = append(b.queue, p...)
+	b.cond.Signal()
+	b.mu.Unlock()
+	return len(p), nil
+}
+
+// Close closes the tee once the queue is written.
+func (b *bufferedWriter) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	b.cond.Signal()
+	b.mu.Unlock()
+	return nil
+}
+
+// run writes the queue to the tee until Close or a failure of the tee.
+func (b *bufferedWriter) run() {
+	for {
+		b.mu.Lock()
+		for len(b.queue) == 0 && !b.closed && !b.failed {
+			b.cond.Wait()
+		}
+		if b.failed || len(b.queue) == 0 {
+			b.mu.Unlock()
+			b.w.Close()
+			return
+		}
+		data := b.queue
+		b.queue = b.spare[:0]
+		b.mu.Unlock()
+
+		if _, err := b.w.Write(data); err != nil {
+			b.mu.Lock()
+			b.failed = true
+			b.queue = nil
+			b.mu.Unlock()
+			return
+		}
+		b.mu.Lock()
+		b.spare = data
+		b.mu.Unlock()
+	}
+}
+
+// Tee creates a new Pipe to duplicate the stream.
+// The stream will pass through all previously pushed functions
+// before going through the tee Pipe.
+// Functions pushed to the original Pipe after a call to Tee will
+// not alter the new Tee Pipe.
+func (p *Pipe) Tee() *Pipe {
+	return p.TeeWithPolicy(Required)
+}
+
+// TeeWithPolicy is like Tee but the failure of the tee
+// is handled according to policy.
+func (p *Pipe) TeeWithPolicy(policy TeePolicy) *Pipe {
+	g := p.group
+	if policy.detach {
+		g = p.group.child(p.onTeeFail(policy))
+	}
+
+	branch := fmt.Sprint(len(p.tees))
+	if p.branch != "" {
+		branch = p.branch + "." + branch
+	}
+
+	tR, tW := io.Pipe()
+	g.add(tR, tW)
+
+	var branchW io.WriteCloser = tW
+	if policy.buffer > 0 {
+		err := &StageError{Branch: branch, Name: ReaderStage, Err: ErrSlowTee}
+		branchW = newBufferedWriter(tW, g, policy.buffer, err)
+	}
+	reader := io.TeeReader(p.reader, branchW)
+	newR, newW := io.Pipe()
+	p.group.add(newR, newW)
+
+	index := len(p.errors)
+	err := make(chan error, 1)
+	p.errors = append(p.errors, err)
+	st := &stage{name: TeeStage}
+	p.stages = append(p.stages, st)
+
+	go func(errCh chan error) {
+		_, err := io.Copy(&countWriter{newW, st}, reader)
+		p.fail(index, TeeStage, err)
+		newW.Close()
+		branchW.Close()
+		errCh <- err
+	}(err)
+
+	tee := newPipe(p.ctx, g, branch, tR)
+	p.tees = append(p.tees, tee)
+	p.reader = newR
+
+	return tee
+}
+
+// Branch identifies the Pipe among its tees, see StageError.
+func (p *Pipe) Branch() string {
+	return p.branch
+}
+
+// onTeeFail returns the function called when a tee with policy fails.
+func (p *Pipe) onTeeFail(policy TeePolicy) func(error) {
+	if !policy.isQuorum {
+		return p.group.detach
+	}
+
+	p.quorum.Lock()
+	p.quorum.total++
+	if p.quorum.total == 1 || policy.quorum > p.quorum.required {
+		p.quorum.required = policy.quorum
+	}
+	p.quorum.Unlock()
+
+	return func(err error) {
+		p.quorum.Lock()
+		p.quorum.failed++
+		lost := p.quorum.total-p.quorum.failed < policy.quorum
+		p.quorum.Unlock()
+
+		if lost {
+			p.group.fail(err)
+		} else {
+			p.group.detach(err)
+		}
+	}
+}
+
+// Detached returns the errors of the tees that failed without
+// interrupting the Pipe, see TeePolicy.
+func (p *Pipe) Detached() Errors {
+	return p.group.detachedErrors()
+}
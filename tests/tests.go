@@ -4,6 +4,8 @@ import (
 	"bufio"
 	"bytes"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 
@@ -63,6 +65,136 @@ func TestReadWriteDeleter(rwd rw.ReadWriteDeleter, id, file string) error {
 	return nil
 }
 
+// Listable is a type that can write, list and delete objects.
+type Listable interface {
+	rw.Lister
+	NewWriter(string) (io.WriteCloser, error)
+	Delete(string) error
+}
+
+// TestLister writes 3 objects with ids starting with prefix and lists
+// them in 2 pages.
+func TestLister(l Listable, prefix string) error {
+	if err := l.Start(); err != nil {
+		return err
+	}
+
+	ids := []string{prefix + "a", prefix + "b", prefix + "c"}
+	for _, id := range ids {
+		w, err := l.NewWriter(id)
+		if err != nil {
+			return err
+		} else if _, err := w.Write([]byte(id)); err != nil {
+			return err
+		} else if err := w.Close(); err != nil {
+			return err
+		}
+		defer l.Delete(id)
+	}
+
+	page, err := l.List(prefix, "", 2)
+	if err != nil {
+		return err
+	} else if fmt.Sprint(page.IDs) != fmt.Sprint(ids[:2]) || page.Next == "" {
+		return fmt.Errorf("invalid first page: %v", page)
+	}
+	if page, err = l.List(prefix, page.Next, 2); err != nil {
+		return err
+	} else if fmt.Sprint(page.IDs) != fmt.Sprint(ids[2:]) || page.Next != "" {
+		return fmt.Errorf("invalid last page: %v", page)
+	}
+	return nil
+}
+
+// Statable is a type that can write objects with metadata, stat and
+// delete them.
+type Statable interface {
+	rw.Stater
+	rw.MetadataWriter
+	Delete(string) error
+}
+
+// TestStater writes an object with metadata and checks its ObjectInfo.
+func TestStater(s Statable, id string) error {
+	if err := s.Start(); err != nil {
+		return err
+	}
+
+	opts := &rw.WriteOptions{ContentType: "text/plain", Metadata: map[string]string{"owner": "tests"}}
+	w, err := s.NewWriterWithOptions(id, opts)
+	if err != nil {
+		return err
+	} else if _, err := w.Write([]byte("some data")); err != nil {
+		return err
+	} else if err := w.Close(); err != nil {
+		return err
+	}
+
+	info, err := s.Stat(id)
+	if err != nil {
+		return err
+	} else if info.Size != 9 || info.ModTime.IsZero() || info.ETag == "" ||
+		info.ContentType != opts.ContentType || info.Metadata["owner"] != "tests" {
+		return fmt.Errorf("invalid object info: %+v", info)
+	}
+
+	if err := s.Delete(id); err != nil {
+		return err
+	} else if _, err := s.Stat(id); !errors.Is(err, rw.ErrNotExist) {
+		return fmt.Errorf("stat of a deleted object should fail with ErrNotExist, got %v", err)
+	}
+	return nil
+}
+
+// RangeReadWriter is a type that can write objects and read parts of them.
+type RangeReadWriter interface {
+	rw.RangeReader
+	NewWriter(string) (io.WriteCloser, error)
+	Delete(string) error
+}
+
+// TestRangeReader writes an object and reads parts of it.
+func TestRangeReader(r RangeReadWriter, id string) error {
+	if err := r.Start(); err != nil {
+		return err
+	}
+
+	const data = "0123456789"
+	w, err := r.NewWriter(id)
+	if err != nil {
+		return err
+	} else if _, err := io.WriteString(w, data); err != nil {
+		return err
+	} else if err := w.Close(); err != nil {
+		return err
+	}
+	defer r.Delete(id)
+
+	ranges := []struct {
+		offset, length int64
+		expected       string
+	}{
+		{0, -1, data},
+		{3, 4, "3456"},
+		{7, -1, "789"},
+		{8, 10, "89"},
+	}
+	for _, rg := range ranges {
+		reader, err := r.NewRangeReader(id, rg.offset, rg.length)
+		if err != nil {
+			return err
+		}
+		res, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return err
+		} else if string(res) != rg.expected {
+			return fmt.Errorf("range %d-%d: expected %q, got %q", rg.offset, rg.length, rg.expected, res)
+		}
+	}
+	return nil
+}
+
 func TestEncoderDecoder(ed encoders.EncoderDecoder, file string) error {
 	var err error
 	var encoded bytes.Buffer
@@ -87,13 +219,15 @@ func TestEncoderDecoder(ed encoders.EncoderDecoder, file string) error {
 	}
 
 	// should open the test file and return the bytes
-	if originalByte, err = ioutil.ReadFile("../../tests/test.jpg"); err != nil {
+	if originalByte, err = ioutil.ReadFile(file); err != nil {
 		return err
 	}
 
 	// should encode test file reader
 	if err := pipe.New(originalReader).Push(ed.Encode).To(encodedWriter).Exec(); err != nil {
 		return err
+	} else if err := encodedWriter.Flush(); err != nil {
+		return err
 	}
 
 	// the encoded bytes should not match the original
@@ -106,6 +240,8 @@ func TestEncoderDecoder(ed encoders.EncoderDecoder, file string) error {
 	// should decoded the encoded writer
 	if err := pipe.New(encodedReader).Push(ed.Decode).To(decodedWriter).Exec(); err != nil {
 		return err
+	} else if err := decodedWriter.Flush(); err != nil {
+		return err
 	}
 
 	// the decoded bytes should match the original